@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileAtime returns the last access time recorded for a file.
+func fileAtime(fi os.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)), true
+}
+
+// deviceNumber returns the device number of a device node, encoded the way
+// the kernel reports controlling terminals in /proc/<pid>/stat.
+func deviceNumber(fi os.FileInfo) (int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.Mode()&os.ModeDevice == 0 {
+		return 0, false
+	}
+	return ttyNr(unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))), true
+}
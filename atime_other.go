@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime returns the last access time recorded for a file. Access times
+// are only read on Linux, so this always reports them as unavailable.
+func fileAtime(fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// deviceNumber returns the device number of a device node. Controlling
+// terminals are only matched on Linux, so this always reports it as
+// unavailable.
+func deviceNumber(fi os.FileInfo) (int, bool) {
+	return 0, false
+}
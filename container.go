@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Files whose presence marks a container: Docker creates /.dockerenv and
+// Podman creates /run/.containerenv.
+var containerMarkers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// initCgroupPath is the cgroup membership of PID 1, which names the container
+// runtime when running under cgroup v1.
+var initCgroupPath = "/proc/1/cgroup"
+
+// containerCgroups are substrings of cgroup paths created by container runtimes.
+var containerCgroups = []string{"docker", "kubepods", "lxc", "containerd", "libpod"}
+
+// isContainer reports whether go-w appears to be running inside a container,
+// where /proc and utmp only show the container's own sessions.
+func isContainer() bool {
+	for _, marker := range containerMarkers {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+
+	data, err := os.ReadFile(initCgroupPath)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, runtime := range containerCgroups {
+			if strings.Contains(line, runtime) {
+				return true
+			}
+		}
+	}
+	return false
+}
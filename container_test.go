@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestIsContainer tests container detection from marker files and cgroups.
+func TestIsContainer(t *testing.T) {
+	oldMarkers := containerMarkers
+	oldCgroupPath := initCgroupPath
+	defer func() {
+		containerMarkers = oldMarkers
+		initCgroupPath = oldCgroupPath
+	}()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		marker   string
+		cgroup   string
+		expected bool
+	}{
+		{missing, "0::/\n", false},
+		{writeTempFile(t, "dockerenv", nil), "0::/\n", true},
+		{missing, "12:memory:/docker/0123456789abcdef\n0::/\n", true},
+		{missing, "11:cpu:/kubepods/besteffort/pod1234\n", true},
+		{missing, "1:name=systemd:/user.slice/user-1000.slice\n", false},
+	}
+
+	for _, test := range tests {
+		containerMarkers = []string{test.marker}
+		initCgroupPath = writeTempFile(t, "cgroup", []byte(test.cgroup))
+
+		result := isContainer()
+		if result != test.expected {
+			t.Errorf("isContainer() with marker %q and cgroup %q = %v; expected %v", test.marker, test.cgroup, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// diagnostics collects what --diag reports: how the session sources fared
+// and how many sessions each later step filled in or kept. Its methods do
+// nothing on a nil receiver, so callers record unconditionally.
+type diagnostics struct {
+	attempts  []sourceAttempt
+	sessions  int // Sessions read from the chosen source
+	idle      int // Sessions with a known idle time
+	loginTime int // Sessions with a known login time
+	shown     int // Sessions left after filtering
+}
+
+// sourceAttempt is the outcome of reading one session source.
+type sourceAttempt struct {
+	name     string
+	sessions int
+	err      error
+}
+
+// diag is the diagnostics being collected, or nil without --diag.
+var diag *diagnostics
+
+// attempt records the outcome of reading a session source.
+func (d *diagnostics) attempt(name string, sessions int, err error) {
+	if d == nil {
+		return
+	}
+	d.attempts = append(d.attempts, sourceAttempt{name, sessions, err})
+}
+
+// enriched records how many sessions have idle and login times.
+func (d *diagnostics) enriched(sessions []UserSession) {
+	if d == nil {
+		return
+	}
+	d.sessions = len(sessions)
+	for _, session := range sessions {
+		if session.IdleDuration != idleUnknown {
+			d.idle++
+		}
+		if !session.LoginTime.IsZero() {
+			d.loginTime++
+		}
+	}
+}
+
+// filtered records how many sessions survived the filters.
+func (d *diagnostics) filtered(n int) {
+	if d == nil {
+		return
+	}
+	d.shown = n
+}
+
+// write prints the diagnostics block, including the size and record count of
+// each candidate utmp file.
+func (d *diagnostics) write(w io.Writer) {
+	fmt.Fprintln(w, "diagnostics:")
+	fmt.Fprintln(w, "  utmp files:")
+	for _, path := range utmpPaths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(w, "    %s: %v\n", path, err)
+			continue
+		}
+		fmt.Fprintf(w, "    %s: %d bytes, %d records\n", path, fi.Size(), fi.Size()/int64(recordSize))
+	}
+	if isMusl() {
+		fmt.Fprintln(w, "    musl libc detected: utmp is not maintained, so sessions come from /proc")
+	}
+
+	fmt.Fprintln(w, "  sources (in priority order):")
+	for _, a := range d.attempts {
+		if a.err != nil {
+			fmt.Fprintf(w, "    %s: skipped: %v\n", a.name, a.err)
+		} else {
+			fmt.Fprintf(w, "    %s: used, %d sessions\n", a.name, a.sessions)
+		}
+	}
+
+	fmt.Fprintln(w, "  enrichment:")
+	fmt.Fprintf(w, "    idle time: %d of %d sessions\n", d.idle, d.sessions)
+	fmt.Fprintf(w, "    login time: %d of %d sessions\n", d.loginTime, d.sessions)
+	fmt.Fprintf(w, "  shown after filters: %d of %d sessions\n", d.shown, d.sessions)
+}
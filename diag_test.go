@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDiagnostics tests collecting and printing the --diag block.
+func TestDiagnostics(t *testing.T) {
+	utmpFile := writeTempFile(t, "utmp", append(mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "", 1672502400), mockUtmpRecord(DEAD_PROCESS, "pts/1", "", "", 0)...))
+	oldUtmpPaths := utmpPaths
+	utmpPaths = []string{utmpFile}
+	defer func() {
+		utmpPaths = oldUtmpPaths
+	}()
+
+	// A nil receiver must be safe, as it is without --diag
+	var none *diagnostics
+	none.attempt("x", 0, nil)
+	none.enriched(nil)
+	none.filtered(0)
+
+	d := &diagnostics{}
+	d.attempt("/run/systemd/sessions", 0, errors.New("systemd is not running"))
+	d.attempt(utmpFile, 2, nil)
+	d.enriched([]UserSession{
+		{IdleDuration: time.Minute, LoginTime: time.Unix(1672502400, 0)},
+		{IdleDuration: idleUnknown},
+	})
+	d.filtered(1)
+
+	var buf bytes.Buffer
+	d.write(&buf)
+	for _, line := range []string{
+		"    " + utmpFile + ": 768 bytes, 2 records\n",
+		"    /run/systemd/sessions: skipped: systemd is not running\n",
+		"    " + utmpFile + ": used, 2 sessions\n",
+		"    idle time: 1 of 2 sessions\n",
+		"    login time: 1 of 2 sessions\n",
+		"  shown after filters: 1 of 2 sessions\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected %q in diagnostics:\n%s", line, buf.String())
+		}
+	}
+}
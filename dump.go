@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// dumpUtmp prints every utmp record read from r with its decoded fields,
+// one record per line, without any of the filtering applied to sessions.
+// It works equally on wtmp and btmp files, which share the format.
+func dumpUtmp(w io.Writer, r io.Reader) error {
+	buf := recordBuffer()
+	var entry utmp
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, buf[:recordSize]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read record %d: %w", i, err)
+		}
+		decodeUtmp(buf, &entry)
+
+		fmt.Fprintf(w, "%d: type=%s pid=%d line=%q id=%q user=%q host=%q session=%d time=%s addr=%s\n",
+			i,
+			utmpTypeName(entry.Type),
+			entry.Pid,
+			cString(entry.Line[:]),
+			cString(entry.ID[:]),
+			cString(entry.User[:]),
+			cString(entry.Host[:]),
+			entry.Session,
+			time.Unix(int64(entry.TimeSec), int64(entry.TimeUsec)*1000).UTC().Format(time.RFC3339Nano),
+			dumpAddr(entry.Addr),
+		)
+	}
+}
+
+// dumpAddr formats a utmp Addr field for --dump, or "-" if it holds no
+// address.
+func dumpAddr(addr [4]int32) string {
+	if s := formatAddr(addr); s != "" {
+		return s
+	}
+	return "-"
+}
+
+// dumpUtmpFile dumps the first utmp file in utmpPaths that can be opened,
+// which is the --utmp-file one if given, and returns the exit code.
+func dumpUtmpFile(w io.Writer) int {
+	var file *os.File
+	var err error
+	for _, path := range utmpPaths {
+		if file, err = openRecordFile(path); err == nil {
+			break
+		}
+		logger.Debug("utmp file unavailable", "path", path, "err", err)
+	}
+	if err != nil {
+		log.Printf("Error: failed to open utmp file: %v", err)
+		return exitError
+	}
+	defer file.Close()
+	if fi, err := file.Stat(); err == nil {
+		checkRecordSize(file.Name(), fi.Size())
+	}
+
+	if err := dumpUtmp(w, bufio.NewReader(file)); err != nil {
+		log.Printf("Error: %s: %v", file.Name(), err)
+		return exitError
+	}
+	return exitOK
+}
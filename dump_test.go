@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestDumpUtmp tests printing the decoded fields of every record, including
+// records that are not sessions.
+func TestDumpUtmp(t *testing.T) {
+	login := utmp{Type: USER_PROCESS, Pid: 1234, Session: 42, TimeSec: 1672502400, TimeUsec: 500000, Addr: addrFromIP("192.168.1.10")}
+	copy(login.Line[:], "pts/0")
+	copy(login.ID[:], "ts/0")
+	copy(login.User[:], "alice")
+	copy(login.Host[:], "client.example.com")
+
+	var data []byte
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-13-amd64", 1672502000)...)
+	data = append(data, encodeUtmp(login)...)
+
+	oldUtmpPaths := utmpPaths
+	utmpPaths = []string{writeTempFile(t, "utmp", data)}
+	defer func() {
+		utmpPaths = oldUtmpPaths
+	}()
+
+	var buf bytes.Buffer
+	if code := dumpUtmpFile(&buf); code != exitOK {
+		t.Fatalf("dumpUtmpFile = %d; expected %d", code, exitOK)
+	}
+	expected := `0: type=BOOT_TIME pid=0 line="~" id="" user="reboot" host="6.1.0-13-amd64" session=0 time=2022-12-31T15:53:20Z addr=-
+1: type=USER_PROCESS pid=1234 line="pts/0" id="ts/0" user="alice" host="client.example.com" session=42 time=2022-12-31T16:00:00.5Z addr=192.168.1.10
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+// TestDumpUtmpTruncated tests that a partial trailing record is an error.
+func TestDumpUtmpTruncated(t *testing.T) {
+	record := mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "", 1672502400)
+	data := append(record, record[:100]...)
+
+	var buf bytes.Buffer
+	err := dumpUtmp(&buf, bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "record 1") {
+		t.Errorf("Expected an error for record 1, got %v", err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Expected the complete record to be printed, got %q", buf.String())
+	}
+}
+
+// TestDumpRecordSize tests dumping a file with a record size other than the
+// native one, and the warning for a length that is not a multiple of it.
+func TestDumpRecordSize(t *testing.T) {
+	defer func() { recordSize = utmpSize }()
+
+	// Records padded to 400 bytes, as a foreign layout might be
+	record := mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "", 1672502400)
+	padded := append(record, make([]byte, 16)...)
+	recordSize = 400
+	var buf bytes.Buffer
+	if err := dumpUtmp(&buf, bytes.NewReader(append(padded, padded...))); err != nil {
+		t.Fatalf("dumpUtmp() error = %v", err)
+	}
+	if n := strings.Count(buf.String(), `user="alice"`); n != 2 {
+		t.Errorf("Expected 2 aligned records, got:\n%s", buf.String())
+	}
+
+	// Records shorter than the native layout are zero-padded when decoded
+	recordSize = 100
+	buf.Reset()
+	if err := dumpUtmp(&buf, bytes.NewReader(record[:200])); err != nil {
+		t.Fatalf("dumpUtmp() error = %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `line="pts/0"`) {
+		t.Errorf("Expected 2 short records, got:\n%s", buf.String())
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	checkRecordSize("wtmp", 250)
+	if !strings.Contains(logs.String(), "wtmp: 250 bytes is not a multiple of the 100-byte record size") {
+		t.Errorf("Expected a record size warning, got %q", logs.String())
+	}
+	logs.Reset()
+	checkRecordSize("wtmp", 300)
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warning for whole records, got %q", logs.String())
+	}
+}
+
+// TestDumpIDSize tests dumping records with a 2-byte ID field, after which
+// the user, host and exit status move but the aligned fields do not.
+func TestDumpIDSize(t *testing.T) {
+	defer setRecordLayout(utmpLayout{IDSize: 4})
+
+	// Laid out by hand as a C compiler lays out the struct with ut_id[2]
+	le := binary.LittleEndian
+	record := make([]byte, 384)
+	le.PutUint16(record[0:], USER_PROCESS)
+	le.PutUint32(record[4:], 1234)
+	copy(record[8:], "pts/0")
+	copy(record[40:], "ts")
+	copy(record[42:], "alice")
+	copy(record[74:], "client.example.com")
+	le.PutUint16(record[332:], 7)
+	le.PutUint32(record[336:], 42)
+	le.PutUint32(record[340:], 1672502400)
+	copy(record[348:], []byte{192, 168, 1, 10})
+
+	setRecordLayout(utmpLayout{IDSize: 2})
+	if recordSize != 384 {
+		t.Errorf("Expected 384-byte records with a 2-byte ID, got %d", recordSize)
+	}
+	var buf bytes.Buffer
+	if err := dumpUtmp(&buf, bytes.NewReader(append(record, record...))); err != nil {
+		t.Fatalf("dumpUtmp() error = %v", err)
+	}
+	expected := `: type=USER_PROCESS pid=1234 line="pts/0" id="ts" user="alice" host="client.example.com" session=42 time=2022-12-31T16:00:00Z addr=192.168.1.10`
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != "0"+expected || lines[1] != "1"+expected {
+		t.Errorf("Expected two records with\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	var entry utmp
+	decodeUtmp(record, &entry)
+	if entry.Exit.Exit != 7 {
+		t.Errorf("Expected exit status 7, got %d", entry.Exit.Exit)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// enrichTimeout bounds each --enrich-cmd invocation.
+var enrichTimeout = 5 * time.Second
+
+// runEnrichCmd runs command once per session, through the shell, with the
+// session as JSON on its stdin, and replaces the session with the JSON
+// session it prints. A session whose command fails, times out or prints
+// invalid JSON is left unchanged.
+func runEnrichCmd(command string, sessions []UserSession) {
+	for i := range sessions {
+		enriched, err := enrichSession(command, sessions[i])
+		if err != nil {
+			log.Printf("Warning: --enrich-cmd failed for %s on %s: %v", sessions[i].User, sessions[i].TTY, err)
+			continue
+		}
+		sessions[i] = enriched
+	}
+}
+
+// enrichSession passes a single session through command.
+func enrichSession(command string, session UserSession) (UserSession, error) {
+	input, err := json.Marshal(session)
+	if err != nil {
+		return session, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), enrichTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	killProcessGroup(cmd)
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return session, fmt.Errorf("timed out after %v", enrichTimeout)
+	} else if err != nil {
+		return session, err
+	}
+
+	// Start from the original so fields the command leaves out are kept
+	enriched := session
+	if err := json.Unmarshal(output, &enriched); err != nil {
+		return session, fmt.Errorf("invalid output: %w", err)
+	}
+	return enriched, nil
+}
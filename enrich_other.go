@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroup leaves cmd unchanged: process groups are a Unix concept,
+// so only the shell itself is killed on a timeout.
+func killProcessGroup(cmd *exec.Cmd) {}
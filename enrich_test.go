@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRunEnrichCmd tests replacing sessions with a command's output and
+// keeping them when the command fails.
+func TestRunEnrichCmd(t *testing.T) {
+	oldEnrichTimeout := enrichTimeout
+	enrichTimeout = 500 * time.Millisecond
+	defer func() {
+		enrichTimeout = oldEnrichTimeout
+	}()
+
+	original := UserSession{User: "alice", TTY: "pts/0", From: "10.0.0.1", Idle: "5:07", What: "-", LoginTime: time.Unix(1672502400, 0)}
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{`sed 's/"from":"[^"]*"/"from":"bastion"/'`, "bastion"},
+		{`echo '{"from": "jump"}'`, "jump"},
+		{"exit 1", "10.0.0.1"},
+		{"echo not json", "10.0.0.1"},
+		{"sleep 5", "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		sessions := []UserSession{original}
+		runEnrichCmd(test.command, sessions)
+		if sessions[0].From != test.expected {
+			t.Errorf("With %q expected FROM %q, got %q", test.command, test.expected, sessions[0].From)
+		}
+		if sessions[0].User != "alice" || !sessions[0].LoginTime.Equal(original.LoginTime) {
+			t.Errorf("With %q expected the other fields kept, got %+v", test.command, sessions[0])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestParseUtmpFIFO tests that a FIFO in place of utmp is rejected without
+// blocking, and that parseUtmp falls back to the next source.
+func TestParseUtmpFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "utmp")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := parseUtmpFile(fifo)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errNotRegular) {
+			t.Errorf("Expected a not a regular file error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("parseUtmpFile blocked on a FIFO")
+	}
+
+	regular := writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "", 1672502400))
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldProcPath := procPath
+	utmpPaths = []string{fifo, regular}
+	logindSessionsDir = fifo + ".missing"
+	procPath = t.TempDir()
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		procPath = oldProcPath
+	}()
+
+	sessions, method, err := parseUtmp()
+	if err != nil {
+		t.Fatalf("parseUtmp failed: %v", err)
+	}
+	if method != "using "+regular || len(sessions) != 1 {
+		t.Errorf("Expected 1 session using %s, got %d %s", regular, len(sessions), method)
+	}
+}
+
+// TestRecordFilesFIFO tests that --dump, --runlevel and --follow reject a
+// FIFO in place of utmp or wtmp without blocking.
+func TestRecordFilesFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "wtmp")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	oldUtmpPaths := utmpPaths
+	utmpPaths = []string{fifo}
+	defer func() { utmpPaths = oldUtmpPaths }()
+
+	tests := []struct {
+		name string
+		f    func() error
+	}{
+		{"dump", func() error {
+			if code := dumpUtmpFile(io.Discard); code != exitError {
+				return fmt.Errorf("exit code %d", code)
+			}
+			return nil
+		}},
+		{"runlevel", func() error {
+			_, err := parseWtmpFile(fifo)
+			if !errors.Is(err, errNotRegular) {
+				return fmt.Errorf("expected a not a regular file error, got %v", err)
+			}
+			return nil
+		}},
+		{"follow", func() error {
+			_, err := newWtmpFollower(fifo)
+			if !errors.Is(err, errNotRegular) {
+				return fmt.Errorf("expected a not a regular file error, got %v", err)
+			}
+			return nil
+		}},
+	}
+	for _, test := range tests {
+		done := make(chan error, 1)
+		go func() { done <- test.f() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s blocked on a FIFO", test.name)
+		}
+	}
+}
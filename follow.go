@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// followInterval is how often --follow checks wtmp for new records.
+var followInterval = time.Second
+
+// wtmpFollower reads the records appended to a wtmp file, like tail -f. It
+// keeps the offset of the first record it has not read yet, and only reads
+// whole records, so one caught mid-write is read on a later poll.
+type wtmpFollower struct {
+	path   string
+	file   *os.File
+	offset int64
+}
+
+// newWtmpFollower opens the wtmp file at path for following from its current
+// end, skipping the history already in it.
+func newWtmpFollower(path string) (*wtmpFollower, error) {
+	f := &wtmpFollower{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	fi, err := f.file.Stat()
+	if err != nil {
+		f.file.Close()
+		return nil, err
+	}
+	f.offset = fi.Size() - fi.Size()%int64(recordSize)
+	return f, nil
+}
+
+// open opens the file at path to read it from the start.
+func (f *wtmpFollower) open() error {
+	file, err := openRecordFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to open wtmp file: %w", err)
+	}
+	f.file, f.offset = file, 0
+	return nil
+}
+
+// poll returns the records appended since the last poll. A file that has been
+// truncated is read again from the start. One that has been rotated away is
+// read to its end and then, once a new file is created at path, replaced by
+// the new file, which is read from the start.
+func (f *wtmpFollower) poll() ([]LoginEvent, error) {
+	cur, err := f.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f.read(cur.Size())
+	} else if err != nil {
+		return nil, err
+	}
+	if os.SameFile(fi, cur) {
+		if cur.Size() < f.offset {
+			f.offset = 0
+		}
+		return f.read(cur.Size())
+	}
+
+	events, err := f.read(cur.Size())
+	if err != nil {
+		return nil, err
+	}
+	f.file.Close()
+	if err := f.open(); err != nil {
+		return events, err
+	}
+	if fi, err = f.file.Stat(); err != nil {
+		return events, err
+	}
+	more, err := f.read(fi.Size())
+	return append(events, more...), err
+}
+
+// read parses the whole records between the offset and size.
+func (f *wtmpFollower) read(size int64) ([]LoginEvent, error) {
+	n := (size - f.offset) / int64(recordSize) * int64(recordSize)
+	if n <= 0 {
+		return nil, nil
+	}
+	events, err := parseWtmpReader(io.NewSectionReader(f.file, f.offset, n))
+	if err != nil {
+		return nil, err
+	}
+	f.offset += n
+	return events, nil
+}
+
+// Close closes the file being followed.
+func (f *wtmpFollower) Close() error {
+	return f.file.Close()
+}
+
+// eventKind names a wtmp record for --follow, or returns "" for records
+// that are not logins, logouts, boots or run-level changes, such as gettys
+// starting.
+func eventKind(event LoginEvent) string {
+	switch event.Type {
+	case USER_PROCESS:
+		return "login"
+	case DEAD_PROCESS:
+		return "logout"
+	case BOOT_TIME:
+		return "boot"
+	case RUN_LVL:
+		if event.User == "shutdown" {
+			return "shutdown"
+		}
+		return "runlevel"
+	}
+	return ""
+}
+
+// displayEvent prints a wtmp record as a line of --follow output: the time,
+// what happened and the user, terminal and host of the record. Logout
+// records only name the terminal.
+func displayEvent(w io.Writer, event LoginEvent) {
+	kind := eventKind(event)
+	if kind == "" {
+		return
+	}
+	line := fmt.Sprintf("%s %-8s %-8s %-12s %s", event.Time.UTC().Format("Mon Jan _2 15:04:05"), kind, event.User, event.TTY, event.Host)
+	fmt.Fprintln(w, strings.TrimRight(line, " "))
+}
+
+// follow prints the logins, logouts and boots recorded in wtmpPath as they
+// happen, until interrupted, and returns the exit code.
+func follow(w io.Writer) int {
+	f, err := newWtmpFollower(wtmpPath)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	defer f.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := f.poll()
+		if err != nil {
+			log.Printf("Error: %s: %v", wtmpPath, err)
+			return exitError
+		}
+		for _, event := range events {
+			displayEvent(w, event)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigs:
+			return exitOK
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWtmpFollower tests reading only the records appended to wtmp, including
+// across partial records, truncation and rotation.
+func TestWtmpFollower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wtmp")
+	login := mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672502400)
+	logout := mockUtmpRecord(DEAD_PROCESS, "pts/0", "", "", 1672506000)
+	if err := os.WriteFile(path, login, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	appendFile := func(data []byte) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectUsers := func(f *wtmpFollower, expected ...string) {
+		t.Helper()
+		events, err := f.poll()
+		if err != nil {
+			t.Fatalf("poll failed: %v", err)
+		}
+		var users []string
+		for _, event := range events {
+			users = append(users, event.User)
+		}
+		if len(users) != len(expected) {
+			t.Fatalf("Expected events for %q, got %q", expected, users)
+		}
+		for i := range users {
+			if users[i] != expected[i] {
+				t.Errorf("Expected events for %q, got %q", expected, users)
+			}
+		}
+	}
+
+	f, err := newWtmpFollower(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { f.Close() }()
+	expectUsers(f) // The existing history is skipped
+
+	bob := mockUtmpRecord(USER_PROCESS, "pts/1", "bob", "", 1672502500)
+	appendFile(append(logout, bob[:100]...))
+	expectUsers(f, "")
+	appendFile(bob[100:])
+	expectUsers(f, "bob")
+
+	// Truncated in place
+	if err := os.WriteFile(path, login, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectUsers(f, "alice")
+
+	// Rotated, with a record written to the old file just before
+	appendFile(logout)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	expectUsers(f, "")
+	if err := os.WriteFile(path, bob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectUsers(f, "bob")
+	appendFile(login)
+	expectUsers(f, "alice")
+}
+
+// TestDisplayEvent tests the --follow line for each kind of record.
+func TestDisplayEvent(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-13-amd64", 1672502400)...)
+	data = append(data, mockUtmpRecord(LOGIN_PROCESS, "tty1", "LOGIN", "", 1672502410)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672502460)...)
+	data = append(data, mockUtmpRecord(DEAD_PROCESS, "pts/0", "", "", 1672506000)...)
+	data = append(data, mockUtmpRecord(RUN_LVL, "~", "shutdown", "6.1.0-13-amd64", 1672509600)...)
+	events, err := parseWtmpReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		displayEvent(&buf, event)
+	}
+	expected := `Sat Dec 31 16:00:00 boot     reboot   ~            6.1.0-13-amd64
+Sat Dec 31 16:01:00 login    alice    pts/0        10.0.0.1
+Sat Dec 31 17:00:00 logout            pts/0
+Sat Dec 31 18:00:00 shutdown shutdown ~            6.1.0-13-amd64
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
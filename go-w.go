@@ -1,16 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
 )
@@ -28,10 +44,49 @@ type utmp struct {
 		Termination int16
 		Exit        int16
 	}
-	Session int32    // Session ID
-	Time    int64    // Time entry was made
-	Addr    [4]int32 // Internet address of remote host
-	Unused  [20]byte // Reserved for future use
+	Session  int32    // Session ID
+	TimeSec  int32    // Time entry was made (seconds)
+	TimeUsec int32    // Time entry was made (microseconds)
+	Addr     [4]int32 // Internet address of remote host
+	Unused   [20]byte // Reserved for future use
+}
+
+// Exit codes. procps w only distinguishes success from failure; the codes
+// from 2 up are go-w's own, for scripts to tell the failures apart.
+const (
+	exitOK          = 0 // Success
+	exitError       = 1 // Runtime error
+	exitUsage       = 2 // Usage error (bad flags)
+	exitUnsupported = 3 // Unsupported platform or missing procfs
+	exitLoadHigh    = 4 // Load average above --load-threshold
+)
+
+// Types of utmp records, as defined in <utmp.h>.
+const (
+	EMPTY         = 0 // Record does not contain valid info
+	RUN_LVL       = 1 // Change in system run-level
+	BOOT_TIME     = 2 // Time of system boot
+	NEW_TIME      = 3 // Time after system clock change
+	OLD_TIME      = 4 // Time before system clock change
+	INIT_PROCESS  = 5 // Process spawned by init
+	LOGIN_PROCESS = 6 // Session leader process for user login
+	USER_PROCESS  = 7 // Normal process
+	DEAD_PROCESS  = 8 // Terminated process
+	ACCOUNTING    = 9 // Not implemented
+)
+
+// utmpTypeNames maps utmp record types to their <utmp.h> names.
+var utmpTypeNames = map[int16]string{
+	EMPTY:         "EMPTY",
+	RUN_LVL:       "RUN_LVL",
+	BOOT_TIME:     "BOOT_TIME",
+	NEW_TIME:      "NEW_TIME",
+	OLD_TIME:      "OLD_TIME",
+	INIT_PROCESS:  "INIT_PROCESS",
+	LOGIN_PROCESS: "LOGIN_PROCESS",
+	USER_PROCESS:  "USER_PROCESS",
+	DEAD_PROCESS:  "DEAD_PROCESS",
+	ACCOUNTING:    "ACCOUNTING",
 }
 
 // SystemInfo holds system-related information.
@@ -39,197 +94,936 @@ type SystemInfo struct {
 	CurrentTime string
 	Uptime      string
 	LoadAvg     string
+	Users       int // Sessions logged in, before any filtering
+
+	// Time is when the information was read, and UptimeDuration the uptime
+	// as a duration, only meaningful when Uptime is not "unknown".
+	Time           time.Time
+	UptimeDuration time.Duration
+
+	// Load1, Load5 and Load15 are the load averages as numbers. They are
+	// only meaningful when LoadAvg is not "unknown".
+	Load1, Load5, Load15 float64
+
+	// LoadSamples are 1-minute load averages sampled by --sample, if any.
+	LoadSamples []float64
+
+	// CPUIdle is the total time the system's CPUs have spent idle since
+	// boot, CPUs how many there are and CPUUtil their resulting average
+	// utilization, from 0 to 1. They are only read for --cpu-idle and are
+	// zero otherwise.
+	CPUIdle time.Duration
+	CPUUtil float64
+	CPUs    int
 }
 
 // UserSession holds information about a logged-in user session.
 type UserSession struct {
-	User    string
-	TTY     string
-	From    string
-	LoginAt string
-	Idle    string
-	JCPU    string
-	PCPU    string
-	What    string
+	User string `json:"user"`
+	TTY  string `json:"tty"`
+	From string `json:"from"`
+	Idle string `json:"idle"`
+	JCPU string `json:"jcpu"`
+	PCPU string `json:"pcpu"`
+	What string `json:"what"`
+	Seat string `json:"seat,omitempty"` // systemd seat (e.g. "seat0"), when known
+
+	Session int `json:"session"`         // Kernel session ID of the login, or 0 if unknown
+	Procs   int `json:"procs,omitempty"` // Processes on the terminal, with --proc-count
+
+	LoginTime    time.Time     `json:"login_time"`    // Login time, or zero if unknown
+	IdleDuration time.Duration `json:"idle_duration"` // Parsed idle time, or idleUnknown
+	Age          time.Duration `json:"age"`           // Time since login, or zero if unknown
+
+	Change sessionChange `json:"-"` // How --watch-diff marks the session
+}
+
+// LoginAt returns the login time formatted for the LOGIN@ column, or "?" if
+// it is unknown.
+func (s UserSession) LoginAt() string {
+	if s.LoginTime.IsZero() {
+		return "?"
+	}
+	return formatTime(s.LoginTime.Unix())
+}
+
+// SessionID returns the session ID formatted for the SID column, or "?" if
+// it is unknown.
+func (s UserSession) SessionID() string {
+	if s.Session == 0 {
+		return "?"
+	}
+	return strconv.Itoa(s.Session)
+}
+
+// AgeString returns the time since login formatted for the AGE column as
+// hours and minutes, "H:MM" like LOGIN@, or "?" if the login time is
+// unknown.
+func (s UserSession) AgeString() string {
+	if s.LoginTime.IsZero() {
+		return "?"
+	}
+	return fmt.Sprintf("%d:%02d", int(s.Age.Hours()), int(s.Age.Minutes())%60)
+}
+
+// idleUnknown is the IdleDuration of sessions whose idle time could not be
+// determined.
+const idleUnknown time.Duration = -1
+
+// options holds the settings parsed from the command line.
+type options struct {
+	User         string   // Show only this user's sessions, if set
+	ExcludeUsers []string // Hide these users' sessions, even if selected by User
+
+	TSV    bool // Print sessions as tab-separated values
+	Who    bool // Print sessions in who(1) format
+	JSON   bool // Print sessions as a JSON array
+	Pretty bool // Indent the JSON output
+	Remote bool // Show only sessions from remote hosts
+	Local  bool // Show only local sessions
+	All    bool // Include init and login processes from utmp
+	Pager  bool // Always pipe the output through a pager
+	Trunc  int  // Maximum width of the FROM column, or 0 for no limit
+	Count  bool // Print only the number of sessions
+	Seat   bool // Show the systemd seat column
+	SID    bool // Show the session ID column
+	NProc  bool // Show the processes-per-terminal column
+	Age    bool // Show the time since login column
+	Tree   bool // List each session's processes under it
+
+	MergeTTYs bool // Show one row per user, listing all of their terminals
+	UserWidth int  // Width of the USER column, truncating longer names, if set
+
+	SummaryLine bool // Print a line with session and user counts under the sessions
+
+	GroupBy string // Group sessions by this key ("host"), if set
+	Table   bool   // Draw sessions in a bordered table
+	Compact bool   // Separate columns by single spaces instead of padding
+	NoColor bool   // Disable colors
+
+	ForceColor bool   // Keep colors even when not writing to a terminal
+	Output     string // Write the output to this file instead of stdout, if set
+
+	Watch     time.Duration // Redraw the output at this interval, if set
+	WatchDiff bool          // Highlight logins and logouts between redraws
+
+	Serve      string        // Serve the sessions as JSON over HTTP on this address, if set
+	ServeCache time.Duration // How long --serve reuses a gather between requests
+
+	Since   time.Duration // Show only sessions that logged in this recently
+	MinIdle time.Duration // Show only sessions idle for longer than this
+
+	IdleAbsolute bool // Show the time of last activity in the IDLE column
+	StatusIcons  bool // Mark each session as active, idle or long idle
+
+	IdleWarn time.Duration // Idle time at which the IDLE column turns yellow
+	IdleCrit time.Duration // Idle time at which the IDLE column turns red
+
+	Format     *template.Template // Per-session output template, if set
+	TimeFormat string             // Layout for the LOGIN@ column
+
+	Theme Theme // Colors of the default output
+
+	UtmpFile string // utmp file to read sessions from instead of the default sources
+	Procfs   string // procfs mount to read instead of /proc, if set
+	Host     string // Read the sessions of this host over ssh, if set
+	Retries  int    // Attempts at reading the utmp file
+
+	RecordSize int // Size of a utmp record on disk, if not the native one
+	IDSize     int // Bytes in a utmp record's ID field, 2 or 4
+
+	Sample        int     // Sample the 1-minute load this many times, if set
+	LoadThreshold float64 // Only check the 1-minute load against this, if set
+	CPUIdle       bool    // Show the CPU idle time and utilization since boot
+	Quiet         bool    // Do not print the --load-threshold message
+
+	LastReboot bool // Print the reboot history from wtmp instead of sessions
+	Failed     bool // Print the failed logins from btmp instead of sessions
+	RunLevel   bool // Print the current run level instead of sessions
+	Dump       bool // Print every raw utmp record instead of sessions
+	InfoOnly   bool // Print only the system information, as JSON
+	HeaderOnly bool // Print only the header line, without sessions
+	Follow     bool // Print wtmp logins and logouts as they happen
+
+	EnrichCmd string // Command each session is passed through as JSON, if set
+
+	Numeric bool // Show UIDs rather than looking up user names
+	Diag    bool // Print diagnostics about the session sources to stderr
+	Verify  bool // Compare the sessions with the system w's on stderr
+	Verbose bool // Log debug messages to stderr
 }
 
+// utmpPaths are the candidate locations of the utmp file, tried in order.
+// Some distributions only provide /run/utmp.
+var utmpPaths = []string{"/run/utmp", "/var/run/utmp"}
+
 // File paths for system information
 var (
-	utmpPath    = "/var/run/utmp"
 	uptimePath  = "/proc/uptime"
 	loadAvgPath = "/proc/loadavg"
+	devPath     = "/dev"
+	passwdPath  = "/etc/passwd"
 )
 
+// nowFunc returns the current time. Tests replace it to make time-dependent
+// output, such as idle times and ages, deterministic.
+var nowFunc = time.Now
+
+// logger receives debug messages. It discards everything unless verbose
+// logging is enabled with setupLogging.
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// timeFormatPresets maps the named --time-format presets to layouts.
+var timeFormatPresets = map[string]string{
+	"w":       "15:04",
+	"iso":     time.RFC3339,
+	"kitchen": time.Kitchen,
+}
+
+// timeFormat is the layout formatTime uses for the LOGIN@ column.
+var timeFormat = timeFormatPresets["w"]
+
+// numericUsers makes the /proc source report UIDs instead of user names.
+var numericUsers bool
+
+// sessionTypes holds the utmp record types that parseUtmpFile reports as
+// sessions.
+var sessionTypes = map[int16]bool{
+	USER_PROCESS: true,
+}
+
 // getSystemInfo retrieves system information (uptime, load averages, etc.).
+// Fields that cannot be read are set to "unknown"; the returned error is a
+// warning describing what was missing, and the info is usable regardless.
 func getSystemInfo() (SystemInfo, error) {
-	uptime, err := readUptime()
-	if err != nil {
-		return SystemInfo{}, fmt.Errorf("failed to read uptime: %w", err)
+	uptime, uptimeErr := readUptime()
+	load, loadErr := readLoadAverage()
+	return newSystemInfo(uptime, uptimeErr, load, loadErr)
+}
+
+// newSystemInfo builds the system information from the uptime and load
+// averages, or the errors reading them, as described for getSystemInfo.
+func newSystemInfo(uptime time.Duration, uptimeErr error, load loadAverage, loadErr error) (SystemInfo, error) {
+	now := nowFunc()
+	info := SystemInfo{
+		CurrentTime: now.Format("15:04:05"),
+		Uptime:      "unknown",
+		LoadAvg:     "unknown",
+		Time:        now,
 	}
 
-	loadAvg, err := readLoadAverage()
-	if err != nil {
-		return SystemInfo{}, fmt.Errorf("failed to read load average: %w", err)
+	var errs []error
+	if uptimeErr != nil {
+		errs = append(errs, fmt.Errorf("failed to read uptime: %w", uptimeErr))
+	} else {
+		info.Uptime = formatUptime(uptime)
+		info.UptimeDuration = uptime
 	}
 
-	return SystemInfo{
-		CurrentTime: time.Now().Format("15:04:05"),
-		Uptime:      formatDuration(uptime),
-		LoadAvg:     loadAvg,
-	}, nil
+	if loadErr != nil {
+		errs = append(errs, fmt.Errorf("failed to read load average: %w", loadErr))
+	} else {
+		info.Load1, info.Load5, info.Load15 = load.values[0], load.values[1], load.values[2]
+		info.LoadAvg = load.text
+	}
+
+	return info, errors.Join(errs...)
 }
 
-// readUptime reads the system uptime from /proc/uptime.
+// maxUptimeSeconds is the largest uptime, about 292 years, that fits in a
+// time.Duration.
+const maxUptimeSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+// readUptime reads the system uptime from /proc/uptime or, if the file
+// cannot be read, from the sysinfo(2) system call where available. The file
+// is preferred for its fractional seconds. The system call describes the
+// kernel go-w runs on, so it is not used for a procfs given with --procfs.
 func readUptime() (time.Duration, error) {
 	data, err := os.ReadFile(uptimePath)
 	if err != nil {
+		if foreignProcfs {
+			return 0, err
+		}
+		// Some sandboxes hide /proc/uptime but allow the system call
+		if uptime, sysErr := sysinfoUptime(); sysErr == nil {
+			logger.Debug("using the sysinfo uptime", "path", uptimePath, "err", err)
+			return uptime, nil
+		}
 		return 0, err
 	}
+	return parseUptime(string(data))
+}
+
+// parseUptime parses the uptime from the contents of /proc/uptime.
+func parseUptime(data string) (time.Duration, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return 0, errors.New("empty uptime file")
+	}
+	return parseUptimeField(fields[0])
+}
 
+// readUptimeFull reads both fields of /proc/uptime: the uptime and the total
+// time all CPUs have spent idle.
+func readUptimeFull() (uptime, idle time.Duration, err error) {
+	data, err := os.ReadFile(uptimePath)
+	if err != nil {
+		return 0, 0, err
+	}
 	fields := strings.Fields(string(data))
-	uptimeSeconds, err := strconv.ParseFloat(fields[0], 64)
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("invalid uptime format")
+	}
+	if uptime, err = parseUptimeField(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	if idle, err = parseUptimeField(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	return uptime, idle, nil
+}
+
+// parseUptimeField parses a number of seconds from /proc/uptime.
+func parseUptimeField(field string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(field, 64)
 	if err != nil {
 		return 0, err
 	}
 
-	return time.Duration(uptimeSeconds * float64(time.Second)), nil
+	// A bogus value would overflow time.Duration and wrap around
+	if !(seconds >= 0 && seconds < maxUptimeSeconds) {
+		return 0, fmt.Errorf("uptime out of range: %s", field)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// cpuUtilization estimates the average CPU utilization since boot, from 0 to
+// 1, from the uptime and the idle time summed over ncpu CPUs.
+func cpuUtilization(uptime, idle time.Duration, ncpu int) float64 {
+	if uptime <= 0 || ncpu <= 0 {
+		return 0
+	}
+	busy := 1 - float64(idle)/(float64(uptime)*float64(ncpu))
+	return min(max(busy, 0), 1)
+}
+
+// loadAverage is the 1, 5 and 15-minute load averages read from
+// /proc/loadavg: as numbers, and as the text of their fields for display.
+type loadAverage struct {
+	values [3]float64
+	text   string
 }
 
-// readLoadAverage reads the system load averages from /proc/loadavg.
-func readLoadAverage() (string, error) {
+// readLoadAverage reads the 1, 5 and 15-minute system load averages from
+// /proc/loadavg.
+func readLoadAverage() (loadAverage, error) {
 	data, err := os.ReadFile(loadAvgPath)
 	if err != nil {
-		return "", err
+		return loadAverage{}, err
 	}
-	fields := strings.Fields(string(data))
-	if len(fields) >= 3 {
-		return strings.Join(fields[:3], " "), nil
+	return parseLoadAverage(string(data))
+}
+
+// parseLoadAverage parses the contents of /proc/loadavg. Decimal commas, as
+// written by tools relaying it under some locales, are accepted in the load
+// fields; the text keeps them as read.
+func parseLoadAverage(data string) (loadAverage, error) {
+	var load loadAverage
+	var err error
+	fields := strings.Fields(data)
+	if len(fields) < 3 {
+		return load, fmt.Errorf("invalid loadavg format")
+	}
+	for i := range load.values {
+		field := strings.Replace(fields[i], ",", ".", 1)
+		if load.values[i], err = strconv.ParseFloat(field, 64); err != nil {
+			return loadAverage{}, fmt.Errorf("invalid loadavg format: %w", err)
+		}
 	}
-	return "", fmt.Errorf("invalid loadavg format")
+	load.text = strings.Join(fields[:3], " ")
+	return load, nil
 }
 
-// parseUtmp reads and parses the utmp file to extract user sessions.
-func parseUtmp() ([]UserSession, string, error) {
-	// Check if /var/run/utmp exists
-	if _, err := os.Stat(utmpPath); err == nil {
-		sessions, err := parseUtmpFile(utmpPath)
-		return sessions, "using /var/run/utmp", err
+// loadSampleInterval is the time between --sample readings. The kernel only
+// recomputes the load averages every five seconds, so sampling faster would
+// just repeat values.
+const loadSampleInterval = 5 * time.Second
+
+// loadSamples are the --sample readings, taken once by execute and shown by
+// every gather after, or nil without --sample.
+var loadSamples []float64
+
+// sampleLoad reads the 1-minute load average n times, interval apart.
+func sampleLoad(n int, interval time.Duration) ([]float64, error) {
+	samples := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		load, err := readLoadAverage()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, load.values[0])
+	}
+	return samples, nil
+}
+
+// loadStats returns the minimum, maximum and mean of a non-empty list of
+// load samples.
+func loadStats(samples []float64) (low, high, avg float64) {
+	low, high = samples[0], samples[0]
+	var sum float64
+	for _, s := range samples {
+		low = min(low, s)
+		high = max(high, s)
+		sum += s
+	}
+	return low, high, sum / float64(len(samples))
+}
+
+// SessionSource is a place logged-in user sessions can be read from.
+type SessionSource interface {
+	// Name describes the source for the header's method string.
+	Name() string
+	// Sessions returns the sessions known to the source.
+	Sessions() ([]UserSession, error)
+}
+
+// utmpSource reads sessions from a utmp file.
+type utmpSource struct {
+	path string
+}
+
+// Name returns the path of the utmp file.
+func (s utmpSource) Name() string {
+	return s.path
+}
+
+// Sessions parses the utmp file. An empty file is treated as unavailable: a
+// maintained utmp always holds at least the boot record, so an empty one is
+// left over from a system, such as one using musl, that does not maintain it.
+func (s utmpSource) Sessions() ([]UserSession, error) {
+	if fi, err := os.Stat(s.path); err == nil && fi.Mode().IsRegular() {
+		if fi.Size() == 0 {
+			return nil, errUtmpEmpty
+		}
+		checkRecordSize(s.path, fi.Size())
+	}
+	return parseUtmpFile(s.path)
+}
+
+// errUtmpEmpty is returned for an empty utmp file.
+var errUtmpEmpty = errors.New("utmp file is empty")
+
+// procSource derives sessions from the processes in /proc.
+type procSource struct{}
+
+// Name returns the procfs directory, normally "/proc".
+func (procSource) Name() string {
+	return procPath
+}
+
+// Sessions scans /proc for processes attached to a terminal.
+func (procSource) Sessions() ([]UserSession, error) {
+	return parseProc()
+}
+
+// utmpFileOnly makes utmpPaths the only session source, for --utmp-file: the
+// sessions of logind or /proc are this system's, not the file's.
+var utmpFileOnly bool
+
+// sessionSources returns the session sources in priority order.
+func sessionSources() []SessionSource {
+	var sources []SessionSource
+	// logind does not track the init and login processes --all adds
+	if !utmpFileOnly && !sessionTypes[LOGIN_PROCESS] {
+		sources = append(sources, logindSource{dir: logindSessionsDir})
+	}
+	for _, path := range utmpPaths {
+		sources = append(sources, utmpSource{path: path})
+	}
+	if !utmpFileOnly {
+		sources = append(sources, procSource{})
 	}
+	return sources
+}
 
-	// Fall back to using /proc
-	sessions, err := parseProc()
-	return sessions, "using /proc", err
+// parseUtmp reads user sessions from the first session source that can be
+// read, and returns them with a method string naming that source.
+func parseUtmp() ([]UserSession, string, error) {
+	var err error
+	for _, source := range sessionSources() {
+		var sessions []UserSession
+		sessions, err = source.Sessions()
+		diag.attempt(source.Name(), len(sessions), err)
+		if err == nil {
+			method := "using " + source.Name()
+			if _, ok := source.(procSource); ok && isMusl() {
+				// Otherwise the missing utmp sessions look like a bug
+				method = "utmp not maintained (musl); " + method
+			}
+			if _, ok := source.(utmpSource); ok && !foreignProcfs && !utmpFileOnly {
+				// A procfs given with --procfs, such as a container's, has
+				// terminals of its own that this system's utmp does not
+				// describe, and the other way round for --utmp-file
+				sessions = fillFromProc(sessions)
+			}
+			return sessions, method, nil
+		}
+		logger.Debug("session source unavailable", "source", source.Name(), "err", err)
+	}
+	return nil, "", err
 }
 
-// parseUtmpFile reads and parses the utmp file.
+// parseUtmpFile reads and parses the utmp file. Reading is retried, with
+// backoff, when it fails other than because the file is missing or
+// unreadable: the file may be caught mid-write, giving a short read.
 func parseUtmpFile(filePath string) ([]UserSession, error) {
-	file, err := os.Open(filePath)
+	var sessions []UserSession
+	err := retry(utmpRetries, utmpRetryDelay, func() error {
+		file, err := openUtmp(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open utmp file: %w", err)
+		}
+		defer file.Close()
+
+		sessions, err = parseUtmpReader(bufio.NewReader(file))
+		return err
+	})
+	return sessions, err
+}
+
+// errNotRegular is returned for a utmp path that is not a regular file.
+var errNotRegular = errors.New("not a regular file")
+
+// openUtmp opens a utmp or wtmp file for reading with openRecordFile. Tests
+// replace it to simulate reads.
+var openUtmp = func(path string) (io.ReadCloser, error) {
+	file, err := openRecordFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// openRecordFile opens a utmp, wtmp or btmp file. Anything but a regular
+// file, such as a FIFO that would block reads forever, is rejected. The file
+// is opened non-blocking so that opening a FIFO does not itself wait for a
+// writer.
+func openRecordFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open utmp file: %w", err)
+		file.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, errNotRegular)
+	}
+	return file, nil
+}
+
+// utmpRetries is how many times parseUtmpFile tries to read the file, and
+// utmpRetryDelay the wait before the first retry, doubled for each one after.
+var (
+	utmpRetries    = 3
+	utmpRetryDelay = 10 * time.Millisecond
+)
+
+// retry calls f up to attempts times, sleeping delay and then twice as long
+// again between tries, until it succeeds or fails with an error that
+// retrying cannot fix: a missing file, a permission error or a file that is
+// not a regular file.
+func retry(attempts int, delay time.Duration, f func() error) error {
+	for i := 1; ; i++ {
+		err := f()
+		if err == nil || i >= attempts || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, errNotRegular) {
+			return err
+		}
+		logger.Debug("retrying", "attempt", i, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// utmpSize is the size in bytes of a utmp record on disk.
+var utmpSize = binary.Size(utmp{})
+
+// recordSize is the size in bytes of the records read from utmp, wtmp and
+// btmp files. It is utmpSize unless overridden with --record-size to read a
+// file from a system with another layout.
+var recordSize = utmpSize
+
+// recordBuffer returns a buffer for reading one record into its first
+// recordSize bytes. It holds at least utmpSize bytes for decodeUtmp, and the
+// bytes past a shorter record stay zero.
+func recordBuffer() []byte {
+	return make([]byte, max(recordSize, utmpSize))
+}
+
+// checkRecordSize warns if a file of size bytes does not hold a whole number
+// of records: it is truncated, or its layout differs from recordSize.
+func checkRecordSize(path string, size int64) {
+	if size%int64(recordSize) != 0 {
+		log.Printf("Warning: %s: %d bytes is not a multiple of the %d-byte record size", path, size, recordSize)
 	}
-	defer file.Close()
+}
+
+// utmpSlot identifies the slot a utmp record occupies: init and login reuse
+// the slot with the same ID and line for each new session on a terminal.
+type utmpSlot struct {
+	id, line string
+}
 
+// parseUtmpReader parses utmp records from r until EOF. A session followed
+// by a DEAD_PROCESS record for the same line has ended, even if its own
+// record was never overwritten, and is dropped. So is a session followed by
+// another in the same slot, as happens when a stale record lingers after the
+// terminal is reused: the last session in each slot wins. Slots are rewritten
+// in place, so file order is not time order, and a dead record older than
+// the session is left over from an earlier one and ignored.
+func parseUtmpReader(r io.Reader) ([]UserSession, error) {
 	var sessions []UserSession
+	latest := make(map[string]int)  // Index in sessions of each line's session
+	slots := make(map[utmpSlot]int) // Index in sessions of each slot's session
+	ghosts := make(map[int]bool)
+
+	// Decode each record by hand from a reused buffer; binary.Read would
+	// reflect over the struct and allocate for every record.
+	buf := recordBuffer()
+	var entry utmp
 	for {
-		var entry utmp
-		if err := binary.Read(file, binary.LittleEndian, &entry); err == io.EOF {
+		if _, err := io.ReadFull(r, buf[:recordSize]); err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, fmt.Errorf("failed to read utmp entry: %w", err)
 		}
+		decodeUtmp(buf, &entry)
+		tty := normalizeTTY(printable(cString(entry.Line[:])))
+
+		if entry.Type == DEAD_PROCESS && tty != "" {
+			dead := time.Unix(int64(entry.TimeSec), int64(entry.TimeUsec)*1000)
+			if i, ok := latest[tty]; ok && !dead.Before(sessions[i].LoginTime) {
+				ghosts[i] = true
+				delete(latest, tty)
+			}
+			continue
+		}
+
+		if sessionTypes[entry.Type] {
+			slot := utmpSlot{cString(entry.ID[:]), tty}
+			if i, ok := slots[slot]; ok {
+				ghosts[i] = true
+			}
+			slots[slot] = len(sessions)
+			latest[tty] = len(sessions)
+			from := printable(cString(entry.Host[:]))
+			if from == "" && isDisplay(tty) {
+				// Graphical logins may record the display only in the line field
+				from = tty
+			}
+			if from == "" {
+				from = formatAddr(entry.Addr)
+			} else {
+				from = normalizeHost(from)
+			}
+
+			// Label records that are not user logins with their type
+			what := "-"
+			if entry.Type != USER_PROCESS {
+				what = utmpTypeName(entry.Type)
+			}
 
-		if entry.Type == 7 { // USER_PROCESS
 			sessions = append(sessions, UserSession{
-				User:    strings.TrimRight(string(entry.User[:]), "\x00"),
-				TTY:     strings.TrimRight(string(entry.Line[:]), "\x00"),
-				From:    strings.TrimRight(string(entry.Host[:]), "\x00"),
-				LoginAt: formatTime(entry.Time),
-				Idle:    ".",
-				JCPU:    "0.00s",
-				PCPU:    "0.00s",
-				What:    "-",
+				User: printable(cString(entry.User[:])),
+				TTY:  tty,
+				From: from,
+				Idle: ".",
+				JCPU: "0.00s",
+				PCPU: "0.00s",
+				What: what,
+
+				Session:   int(entry.Session),
+				LoginTime: time.Unix(int64(entry.TimeSec), int64(entry.TimeUsec)*1000),
 			})
 		}
 	}
 
+	if len(ghosts) > 0 {
+		live := sessions[:0]
+		for i, session := range sessions {
+			if !ghosts[i] {
+				live = append(live, session)
+			}
+		}
+		sessions = live
+	}
 	return sessions, nil
 }
 
-// parseProc retrieves logged-in users using /proc.
+// utmpLayout describes how the fields of a utmp record are laid out on disk,
+// for reading files from systems other than glibc on Linux. The only
+// variation supported is the width of the ID field, which was 2 bytes on
+// some older systems rather than 4. The record is a C struct with its fields
+// naturally aligned, so only the strings and exit status after ID move with
+// it: the session, time and address realign to the same offsets as in the
+// native layout.
+type utmpLayout struct {
+	IDSize int // Bytes in the ID field, 2 or 4
+}
+
+// utmpOffsets are the offsets of the fields after ID in a utmp record, and
+// the size of the record.
+type utmpOffsets struct {
+	user, host, exit, session, tv, addr, unused, size int
+}
+
+// offsets computes where the fields after ID start in layout l, padding each
+// to its alignment as a C compiler would.
+func (l utmpLayout) offsets() utmpOffsets {
+	var o utmpOffsets
+	o.user = 40 + l.IDSize
+	o.host = o.user + 32
+	o.exit = alignUp(o.host+256, 2)
+	o.session = alignUp(o.exit+4, 4)
+	o.tv = o.session + 4
+	o.addr = o.tv + 8
+	o.unused = o.addr + 16
+	o.size = alignUp(o.unused+20, 4)
+	return o
+}
+
+// alignUp rounds n up to a multiple of align.
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// recordLayout is the layout decodeUtmp reads records with, the native one
+// unless overridden with --id-size.
+var recordLayout = utmpLayout{IDSize: 4}
+
+// setRecordLayout makes decodeUtmp read records in layout l, and sets
+// recordSize to the size of a record in it, which --record-size may then
+// override.
+func setRecordLayout(l utmpLayout) {
+	recordLayout = l
+	recordSize = l.offsets().size
+}
+
+// decodeUtmp decodes a little-endian utmp record in recordLayout from b,
+// which must hold at least utmpSize bytes.
+func decodeUtmp(b []byte, entry *utmp) {
+	le := binary.LittleEndian
+	entry.Type = int16(le.Uint16(b[0:]))
+	entry.Pid = int32(le.Uint32(b[4:]))
+	copy(entry.Line[:], b[8:40])
+	entry.ID = [4]byte{}
+	copy(entry.ID[:], b[40:40+recordLayout.IDSize])
+
+	o := recordLayout.offsets()
+	copy(entry.User[:], b[o.user:o.user+32])
+	copy(entry.Host[:], b[o.host:o.host+256])
+	entry.Exit.Termination = int16(le.Uint16(b[o.exit:]))
+	entry.Exit.Exit = int16(le.Uint16(b[o.exit+2:]))
+	entry.Session = int32(le.Uint32(b[o.session:]))
+	entry.TimeSec = int32(le.Uint32(b[o.tv:]))
+	entry.TimeUsec = int32(le.Uint32(b[o.tv+4:]))
+	for i := range entry.Addr {
+		entry.Addr[i] = int32(le.Uint32(b[o.addr+i*4:]))
+	}
+	copy(entry.Unused[:], b[o.unused:o.unused+20])
+}
+
+// cString converts a NUL-padded utmp string field to a string, stopping at
+// the first NUL.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// printable makes text from utmp, which may have come from a misbehaving
+// client, safe to print and to encode as JSON. Invalid UTF-8 is replaced
+// with U+FFFD, and control characters, which could drive the terminal, are
+// escaped as \xNN.
+func printable(s string) string {
+	if utf8.ValidString(s) && strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(s, "\uFFFD") {
+		if unicode.IsControl(r) {
+			fmt.Fprintf(&b, "\\x%02x", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// utmpTypeName returns the <utmp.h> name of a utmp record type.
+func utmpTypeName(t int16) string {
+	if name, ok := utmpTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE_%d", t)
+}
+
+// parseProc retrieves logged-in users using /proc: one session for each
+// terminal with processes on it, taken from the process with the lowest
+// PID, normally the login shell. Processes without a controlling terminal,
+// such as daemons, are not sessions. Processes on a terminal that cannot be
+// identified are grouped by kernel session instead.
 func parseProc() ([]UserSession, error) {
 	var sessions []UserSession
 
-	// Iterate over all processes in /proc
-	procDir := "/proc"
-	entries, err := os.ReadDir(procDir)
+	pids, err := procPIDs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read /proc: %w", err)
+		return nil, err
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	// The boot time turns process start times into login times
+	boot, err := bootTime()
+	if err != nil {
+		logger.Debug("login times unavailable", "err", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, pid := range pids {
+		// Skip kernel threads before reading their status and stat files;
+		// there are often hundreds of them and none has a terminal
+		if isKernelThread(pid) {
 			continue
 		}
 
-		pid, err := strconv.Atoi(entry.Name())
+		// Get the terminal (TTY) for the process
+		tty, err := getTTYFromPID(pid)
 		if err != nil {
-			continue // Skip non-PID directories
+			logger.Debug("skipping pid", "pid", pid, "err", err)
+			continue
+		}
+		if tty == "" {
+			continue
+		}
+
+		// Use the process start time as the login time
+		var loginTime time.Time
+		var sid int
+		if stat, err := readProcStat(pid); err == nil {
+			sid = stat.Session
+			if !boot.IsZero() {
+				loginTime = procStartTime(boot, stat)
+			}
+		} else {
+			logger.Debug("login time unavailable", "pid", pid, "err", err)
+		}
+
+		// Only the first process on each terminal, before looking up its user
+		key := tty
+		if tty == "?" {
+			key = "?" + strconv.Itoa(sid)
+		}
+		if seen[key] {
+			continue
 		}
 
 		// Get the username for the process
 		user, err := getUserFromPID(pid)
 		if err != nil {
+			logger.Debug("skipping pid", "pid", pid, "err", err)
 			continue
 		}
+		seen[key] = true
 
-		// Get the terminal (TTY) for the process
-		tty, err := getTTYFromPID(pid)
-		if err != nil {
-			continue
+		// The remote host is only known for SSH logins, from the environment,
+		// which is unreadable for other users' processes unless root
+		from := "?"
+		if chain, err := getSSHChain(pid); err == nil && len(chain) > 0 {
+			from = strings.Join(chain, ">")
 		}
 
 		// Add the session to the list
 		sessions = append(sessions, UserSession{
-			User:    user,
-			TTY:     tty,
-			From:    "?", // Remote host not available in /proc
-			LoginAt: "?", // Login time not available in /proc
-			Idle:    ".",
-			JCPU:    "0.00s",
-			PCPU:    "0.00s",
-			What:    "-",
+			User: user,
+			TTY:  tty,
+			From: from,
+			Idle: ".",
+			JCPU: "0.00s",
+			PCPU: "0.00s",
+			What: "-",
+
+			Session:   sid,
+			LoginTime: loginTime,
 		})
 	}
 
 	return sessions, nil
 }
 
-// getUserFromPID retrieves the username for a given process ID.
+// procPIDs lists the processes in /proc in numerical order, which os.ReadDir
+// does not give: it sorts "1000" before "999". Listing /proc can fail part
+// way on some kernels, which still leaves the processes listed until then.
+func procPIDs() ([]int, error) {
+	entries, err := readProcDir(procPath)
+	if err != nil && len(entries) == 0 {
+		return nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	} else if err != nil {
+		logger.Debug("partial listing", "path", procPath, "entries", len(entries), "err", err)
+	}
+
+	pids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	slices.Sort(pids)
+	return pids, nil
+}
+
+// getUserFromPID retrieves the username for a given process ID. With
+// numericUsers set it returns the UID itself, without a name lookup.
 func getUserFromPID(pid int) (string, error) {
-	statusFile := fmt.Sprintf("/proc/%d/status", pid)
-	data, err := os.ReadFile(statusFile)
+	uid, err := getUIDFromPID(pid)
+	if err != nil {
+		return "", err
+	}
+	if numericUsers {
+		return strconv.Itoa(uid), nil
+	}
+	username, err := lookupUsername(uid)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user by UID: %w", err)
+	}
+	return username, nil
+}
+
+// getUIDFromPID reads the real UID of a process from /proc/<pid>/status.
+func getUIDFromPID(pid int) (int, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "status"))
 	if err != nil {
-		return "", fmt.Errorf("failed to read status file: %w", err)
-	}
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "Uid:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				uid, err := strconv.Atoi(fields[1])
-				if err != nil {
-					return "", fmt.Errorf("failed to parse UID: %w", err)
-				}
-				user, err := getUserByUID(uid)
-				if err != nil {
-					return "", fmt.Errorf("failed to get user by UID: %w", err)
-				}
-				return user.Username, nil
+		return 0, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "Uid:" {
+			uid, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse UID: %w", err)
 			}
+			return uid, nil
 		}
 	}
-	return "", fmt.Errorf("UID not found in status file")
+	return 0, fmt.Errorf("UID not found in status file")
 }
 
 // getUserByUID retrieves the username for a given UID.
@@ -237,11 +1031,71 @@ func getUserByUID(uid int) (*user.User, error) {
 	return user.LookupId(strconv.Itoa(uid))
 }
 
-// getTTYFromPID retrieves the terminal (TTY) for a given process ID.
-func getTTYFromPID(pid int) (string, error) {
-	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
-	entries, err := os.ReadDir(fdDir)
+// lookupUsername resolves a UID to a username. If os/user cannot resolve it,
+// as happens in some static builds, it falls back to reading passwdPath
+// directly.
+func lookupUsername(uid int) (string, error) {
+	u, err := getUserByUID(uid)
+	if err == nil {
+		return u.Username, nil
+	}
+
+	name, passwdErr := lookupPasswd(uid)
+	if passwdErr != nil {
+		return "", fmt.Errorf("%w (passwd fallback: %v)", err, passwdErr)
+	}
+	return name, nil
+}
+
+// lookupPasswd finds the username for a UID in the passwd file.
+func lookupPasswd(uid int) (string, error) {
+	data, err := os.ReadFile(passwdPath)
 	if err != nil {
+		return "", err
+	}
+
+	want := strconv.Itoa(uid)
+	for _, line := range strings.Split(string(data), "\n") {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(line, ":")
+		if len(fields) >= 3 && fields[2] == want {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("UID %d not found in %s", uid, passwdPath)
+}
+
+// readProcDir lists the procfs directory. Tests replace it to simulate a
+// listing that fails part way.
+var readProcDir = os.ReadDir
+
+// readFdDir lists a /proc/<pid>/fd directory. Tests replace it to simulate
+// permission errors, which root never gets.
+var readFdDir = os.ReadDir
+
+// getTTYFromPID retrieves the controlling terminal (TTY) for a given process
+// ID, "" if it has none, or "?" if it has one that cannot be identified.
+func getTTYFromPID(pid int) (string, error) {
+	// Prefer the controlling terminal; a process may hold other terminals
+	// open without them being its own
+	if stat, err := readProcStat(pid); err == nil {
+		if stat.TTYNr == 0 {
+			return "", nil
+		}
+		if tty, ok := ttyName(stat.TTYNr); ok {
+			return tty, nil
+		}
+	}
+
+	// Otherwise fall back to the first terminal among its open files. The
+	// fd directories of other users' processes are unreadable; such a
+	// process still counts, with an unknown terminal.
+	fdDir := filepath.Join(procPath, strconv.Itoa(pid), "fd")
+	entries, err := readFdDir(fdDir)
+	if errors.Is(err, fs.ErrPermission) {
+		logger.Debug("terminal unknown", "pid", pid, "err", err)
+		return "?", nil
+	} else if err != nil {
 		return "", fmt.Errorf("failed to read fd directory: %w", err)
 	}
 
@@ -251,15 +1105,315 @@ func getTTYFromPID(pid int) (string, error) {
 			continue
 		}
 		if strings.HasPrefix(link, "/dev/tty") || strings.HasPrefix(link, "/dev/pts") {
-			return filepath.Base(link), nil
+			return normalizeTTY(link), nil
 		}
 	}
 	return "?", nil
 }
 
-// formatTime formats a Unix timestamp into a human-readable time string.
+// enrichSessions fills in the session fields that are computed from the live
+// system rather than read from the session source.
+func enrichSessions(sessions []UserSession) {
+	// The boot time bounds plausible idle times; without it only the
+	// device access times are used
+	boot, err := bootTime()
+	if err != nil {
+		logger.Debug("boot time unavailable", "err", err)
+	}
+
+	for i := range sessions {
+		if !sessions[i].LoginTime.IsZero() {
+			sessions[i].Age = max(nowFunc().Sub(sessions[i].LoginTime), 0)
+		}
+		sessions[i].What = describeWhat(sessions[i])
+
+		idle, err := ttyIdle(sessions[i].TTY, boot)
+		if err != nil {
+			logger.Debug("idle time unavailable", "tty", sessions[i].TTY, "err", err)
+			sessions[i].Idle = "?"
+			sessions[i].IdleDuration = idleUnknown
+			continue
+		}
+		sessions[i].Idle = formatIdle(idle)
+		sessions[i].IdleDuration = idle
+	}
+}
+
+// graphicalSessionCommands are the display managers, session managers and
+// display servers that run a graphical login. Rather than one of their
+// binaries, the WHAT column shows such sessions as a "graphical session".
+var graphicalSessionCommands = map[string]bool{
+	"gdm-session-worker":   true,
+	"gdm-wayland-session":  true,
+	"gdm-x-session":        true,
+	"gnome-session":        true,
+	"gnome-session-binary": true,
+	"sddm-helper":          true,
+	"startplasma-wayland":  true,
+	"startplasma-x11":      true,
+	"plasma_session":       true,
+	"lightdm":              true,
+	"xfce4-session":        true,
+	"mate-session":         true,
+	"cinnamon-session":     true,
+	"lxsession":            true,
+	"xinit":                true,
+	"Xorg":                 true,
+	"Xwayland":             true,
+}
+
+// graphicalSession is the WHAT of sessions running a graphical login.
+const graphicalSession = "graphical session"
+
+// describeWhat returns the WHAT of a session: "graphical session" if its
+// command is one of graphicalSessionCommands, or if it is logged in on an X
+// display and has no command of its own, and otherwise the command as is. The
+// session sources record no command, so for a session without one that of
+// its leader process is checked instead.
+func describeWhat(session UserSession) string {
+	what := session.What
+	command := what
+	if what == "-" && session.Session > 0 {
+		command = procCommand(session.Session, "")
+	}
+	if fields := strings.Fields(command); len(fields) > 0 && graphicalSessionCommands[filepath.Base(fields[0])] {
+		return graphicalSession
+	}
+	if what == "-" && (isDisplay(session.TTY) || isDisplay(session.From)) {
+		return graphicalSession
+	}
+	return what
+}
+
+// isDisplay reports whether a tty name refers to an X11 or Wayland display
+// (such as ":0") rather than a terminal device.
+func isDisplay(tty string) bool {
+	return strings.HasPrefix(tty, ":")
+}
+
+// normalizeTTY converts the terminal names found in utmp and /proc into the
+// "pts/N" and "ttyX" form used throughout go-w. It strips a leading "/dev/"
+// and turns a bare pseudo-terminal number, as left by taking the basename of
+// "/dev/pts/N", back into "pts/N". Anything else, such as a display name, is
+// returned unchanged.
+func normalizeTTY(raw string) string {
+	tty := strings.TrimPrefix(raw, "/dev/")
+	if tty != "" && strings.Trim(tty, "0123456789") == "" {
+		return "pts/" + tty
+	}
+	return tty
+}
+
+// ttyPattern matches the terminal names that may be looked up under devPath.
+var ttyPattern = regexp.MustCompile(`^(tty[A-Za-z0-9]*|pts/[0-9]+|console)$`)
+
+// ttyDevicePath returns the device node for a tty name, or false if the name
+// does not refer to a terminal device. Names come from utmp and may be
+// corrupt or crafted, so anything other than a plain "ttyX", "pts/N" or
+// "console" name is rejected rather than joined onto devPath.
+func ttyDevicePath(tty string) (string, bool) {
+	if !ttyPattern.MatchString(tty) {
+		return "", false
+	}
+	return filepath.Join(devPath, tty), true
+}
+
+// idleClockSkew is how far in the future a device access time may be before
+// it is considered bogus rather than clock jitter.
+const idleClockSkew = time.Minute
+
+// ttyIdle returns how long a terminal has been idle, based on the last access
+// time of its device node. When that time is implausible, in the future or
+// before boot, the idle time is instead estimated from the start of the
+// terminal's newest foreground process. A stale access time that is merely
+// old, as on a /dev mounted noatime, looks plausible and is used as it is.
+func ttyIdle(tty string, boot time.Time) (time.Duration, error) {
+	path, ok := ttyDevicePath(tty)
+	if !ok {
+		return 0, fmt.Errorf("%q is not a terminal device", tty)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	atime, ok := fileAtime(fi)
+	if !ok {
+		return 0, fmt.Errorf("access time not available for %s", path)
+	}
+
+	idle := nowFunc().Sub(atime)
+	if plausibleIdle(idle, boot) {
+		return max(idle, 0), nil
+	}
+	logger.Debug("implausible access time", "tty", tty, "atime", atime)
+
+	nr, ok := deviceNumber(fi)
+	if !ok || boot.IsZero() {
+		return 0, fmt.Errorf("implausible access time for %s", path)
+	}
+	start, err := foregroundStart(nr, boot)
+	if err != nil {
+		return 0, fmt.Errorf("implausible access time for %s: %w", path, err)
+	}
+	return max(nowFunc().Sub(start), 0), nil
+}
+
+// plausibleIdle reports whether an idle time derived from a device access
+// time can be believed: the access must not be in the future, beyond some
+// clock skew, or before the system booted. A zero boot time skips the latter
+// check.
+func plausibleIdle(idle time.Duration, boot time.Time) bool {
+	if idle < -idleClockSkew {
+		return false
+	}
+	return boot.IsZero() || idle <= nowFunc().Sub(boot)
+}
+
+// isRemote reports whether a session came in over the network. Sessions with
+// no recorded origin, an unknown origin ("?"), or an X display (":0") are
+// considered local.
+func isRemote(session UserSession) bool {
+	from := session.From
+	return from != "" && from != "?" && !strings.HasPrefix(from, ":")
+}
+
+// loggedInSince reports whether a session logged in at or after cutoff.
+// Sessions with an unknown login time never match.
+func loggedInSince(session UserSession, cutoff time.Time) bool {
+	return !session.LoginTime.IsZero() && !session.LoginTime.Before(cutoff)
+}
+
+// idleSince returns the time of a session's last activity, formatted like
+// the LOGIN@ column, or "?" if its idle time is unknown.
+func idleSince(session UserSession, now time.Time) string {
+	if session.IdleDuration == idleUnknown {
+		return "?"
+	}
+	return formatTime(now.Add(-session.IdleDuration).Unix())
+}
+
+// idleLongerThan reports whether a session has been idle for longer than min.
+// Sessions with an unknown idle time never match.
+func idleLongerThan(session UserSession, min time.Duration) bool {
+	return session.IdleDuration != idleUnknown && session.IdleDuration > min
+}
+
+// filterSessions returns the sessions for which keep returns true.
+func filterSessions(sessions []UserSession, keep func(UserSession) bool) []UserSession {
+	var filtered []UserSession
+	for _, session := range sessions {
+		if keep(session) {
+			filtered = append(filtered, session)
+		}
+	}
+	return filtered
+}
+
+// normalizeHost rewrites a host that is an IP literal into the canonical form
+// used by formatAddr, so addresses look the same whether utmp stored them as
+// text or in binary. Hostnames and display names are returned unchanged.
+func normalizeHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// addrFamily infers the address family of a utmp Addr field. The record has
+// no explicit family flag, so the guess is based on which words are set: only
+// the first word means IPv4, any of the trailing words means IPv6, and none
+// at all means no address was recorded.
+//
+// The heuristic cannot tell an IPv6 address whose last 96 bits are zero (such
+// as "2001:db8::") apart from an IPv4 address, and reports it as AF_INET.
+func addrFamily(addr [4]int32) int {
+	switch {
+	case addr[1] != 0 || addr[2] != 0 || addr[3] != 0:
+		return syscall.AF_INET6
+	case addr[0] != 0:
+		return syscall.AF_INET
+	default:
+		return syscall.AF_UNSPEC
+	}
+}
+
+// formatAddr formats a utmp Addr field as an IP address string, or returns
+// an empty string if no address was recorded.
+func formatAddr(addr [4]int32) string {
+	switch addrFamily(addr) {
+	case syscall.AF_INET:
+		return formatAddrV4(addr)
+	case syscall.AF_INET6:
+		return formatAddrV6(addr)
+	default:
+		return ""
+	}
+}
+
+// formatAddrV4 formats the first word of a utmp Addr field as an IPv4 address.
+func formatAddrV4(addr [4]int32) string {
+	return net.IP(appendAddrWord(make(net.IP, 0, net.IPv4len), addr[0])).String()
+}
+
+// formatAddrV6 formats all four words of a utmp Addr field as an IPv6 address.
+func formatAddrV6(addr [4]int32) string {
+	ip := make(net.IP, 0, net.IPv6len)
+	for _, word := range addr {
+		ip = appendAddrWord(ip, word)
+	}
+	return ip.String()
+}
+
+// appendAddrWord appends the bytes of a utmp Addr word to ip in the order
+// they are stored on disk. The address itself is in network byte order, but
+// decodeUtmp reads each word as a little-endian integer, so the first byte
+// of the address is the low byte of the word: 1.2.3.4 is 0x04030201.
+func appendAddrWord(ip net.IP, word int32) net.IP {
+	return append(ip, byte(word), byte(word>>8), byte(word>>16), byte(word>>24))
+}
+
+// formatTime formats a Unix timestamp into a human-readable time string,
+// using timeFormat.
 func formatTime(sec int64) string {
-	return time.Unix(sec, 0).UTC().Format("15:04")
+	return time.Unix(sec, 0).UTC().Format(timeFormat)
+}
+
+// parseTimeFormat resolves a --time-format value, which is either the name
+// of a preset or a Go reference-time layout. Layouts that contain no
+// reference-time elements at all are rejected.
+func parseTimeFormat(value string) (string, error) {
+	if layout, ok := timeFormatPresets[value]; ok {
+		return layout, nil
+	}
+
+	// Any layout element renders this time differently from the reference
+	// time it is spelled with, so an unchanged result means there were none
+	probe := time.Date(1999, time.November, 28, 9, 37, 41, 0, time.UTC)
+	if value == "" || probe.Format(value) == value {
+		return "", fmt.Errorf("invalid time format %q: not a preset (w, iso, kitchen) or Go time layout", value)
+	}
+	return value, nil
+}
+
+// truncate shortens s to at most n characters, replacing the tail with an
+// ellipsis. A limit of 0 or less leaves s unchanged.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// formatDate formats a login time with its full date, as who(1) does. Like
+// formatTime it uses UTC; unknown times are shown as "?".
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return t.UTC().Format("2006-01-02 15:04")
 }
 
 // formatDuration formats a duration into a human-readable string (e.g., "1:23").
@@ -273,54 +1427,912 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%d:%02d", minutes, seconds)
 }
 
-// displayHeader prints the header of the `w` output with colors.
-func displayHeader(info SystemInfo, method string) {
-	cyan := color.New(color.FgCyan).SprintFunc()
-	yellow := color.New(color.FgYellow).SprintFunc()
-
-	fmt.Printf(" %s up %s,  load average: %s (%s)\n",
-		cyan(info.CurrentTime),
-		yellow(info.Uptime),
-		yellow(info.LoadAvg),
+// formatUptime formats the uptime for the header: formatDuration's "H:MM:SS"
+// with a day count in front once the system has been up a day, so a long
+// uptime reads "400 days, 3:04:05" rather than as thousands of hours.
+func formatUptime(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	rest := formatDuration(d % (24 * time.Hour))
+	if days == 0 {
+		return rest
+	}
+	return plural(days, "day", "days") + ", " + rest
+}
+
+// plural formats a count with the singular or plural form of its noun, as in
+// "1 user" and "2 users".
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// formatIdle formats an idle duration the way w does: seconds with
+// hundredths under a minute, "M:SS" under an hour, "H:MMm" under two days,
+// and whole days beyond that.
+func formatIdle(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%ddays", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%d:%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%d.%02ds", int(d.Seconds()), int(d.Milliseconds()/10)%100)
+	}
+}
+
+// displaySummary prints the first header line: the time, uptime and load
+// averages, and where the sessions came from.
+func displaySummary(w io.Writer, info SystemInfo, method string, theme Theme) {
+	fmt.Fprintf(w, " %s up %s,  %s,  load average: %s (%s)\n",
+		paint(theme.Time, info.CurrentTime),
+		paint(theme.Uptime, info.Uptime),
+		plural(info.Users, "user", "users"),
+		paint(theme.Load, info.LoadAvg),
 		method,
 	)
-	fmt.Println(color.New(color.FgHiWhite).Sprint("USER     TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT"))
+
+	if info.CPUs > 0 {
+		fmt.Fprintf(w, " cpu idle: %s across %s, %.1f%% average utilization since boot\n",
+			formatUptime(info.CPUIdle),
+			plural(info.CPUs, "CPU", "CPUs"),
+			info.CPUUtil*100,
+		)
+	}
+
+	if len(info.LoadSamples) > 0 {
+		low, high, avg := loadStats(info.LoadSamples)
+		fmt.Fprintf(w, " load trend: min %s, max %s, avg %s (%s)\n",
+			paint(theme.Load, fmt.Sprintf("%.2f", low)),
+			paint(theme.Load, fmt.Sprintf("%.2f", high)),
+			paint(theme.Load, fmt.Sprintf("%.2f", avg)),
+			plural(len(info.LoadSamples), "sample", "samples"),
+		)
+	}
 }
 
-// displaySessions prints the list of user sessions with colors.
-func displaySessions(sessions []UserSession) {
-	green := color.New(color.FgGreen).SprintFunc()
-	blue := color.New(color.FgBlue).SprintFunc()
-	magenta := color.New(color.FgMagenta).SprintFunc()
+// displayHeader prints the header of the `w` output with colors.
+func displayHeader(w io.Writer, info SystemInfo, method string, opts options) {
+	theme := opts.Theme
+	displaySummary(w, info, method, theme)
 
+	if opts.Compact {
+		headings, _ := sessionTable(nil, opts)
+		columns := strings.Join(headings, " ")
+		if opts.StatusIcons {
+			columns = "  " + columns
+		}
+		fmt.Fprintln(w, paint(theme.Columns, columns))
+		return
+	}
+
+	columns := fmt.Sprintf("%-*s TTY      ", userColumnWidth(opts), "USER")
+	if opts.StatusIcons {
+		columns = "  " + columns
+	}
+	if opts.SID {
+		columns += "SID      "
+	}
+	if opts.Seat {
+		columns += "SEAT     "
+	}
+	if opts.NProc {
+		columns += "NPROC "
+	}
+	columns += "FROM             LOGIN@   "
+	if opts.Age {
+		columns += "AGE      "
+	}
+	columns += "IDLE   JCPU   PCPU WHAT"
+	fmt.Fprintln(w, paint(theme.Columns, columns))
+}
+
+// userColumnWidth returns the width of the USER column: --user-width if set,
+// or else 8, as in w, which longer names overflow.
+func userColumnWidth(opts options) int {
+	if opts.UserWidth > 0 {
+		return opts.UserWidth
+	}
+	return 8
+}
+
+// displaySessions prints the list of user sessions in the colors of
+// opts.Theme.
+func displaySessions(w io.Writer, sessions []UserSession, opts options) {
+	if opts.Compact {
+		displayCompact(w, sessions, opts)
+		return
+	}
+
+	theme := opts.Theme
 	for _, session := range sessions {
-		fmt.Printf("%-8s %-8s %-16s %-8s %-6s %-6s %-6s %s\n",
-			green(session.User),
-			blue(session.TTY),
-			magenta(session.From),
-			session.LoginAt,
-			session.Idle,
+		idle := paintPadded(theme.idleColor(session.IdleDuration, opts.IdleWarn, opts.IdleCrit), session.Idle, 6)
+
+		if opts.StatusIcons {
+			fmt.Fprintf(w, "%s ", theme.statusIcon(session.IdleDuration, opts.IdleWarn, opts.IdleCrit))
+		}
+		userColor, ttyColor := theme.sessionColors(session)
+		fmt.Fprintf(w, "%s %s ", paintPadded(userColor, truncate(session.User, opts.UserWidth), userColumnWidth(opts)), paintPadded(ttyColor, session.TTY, 8))
+		if opts.SID {
+			fmt.Fprintf(w, "%-8s ", session.SessionID())
+		}
+		if opts.Seat {
+			fmt.Fprintf(w, "%-8s ", session.Seat)
+		}
+		if opts.NProc {
+			fmt.Fprintf(w, "%-5d ", session.Procs)
+		}
+		fmt.Fprintf(w, "%s %-8s ", paintPadded(theme.From, truncate(session.From, opts.Trunc), 16), session.LoginAt())
+		if opts.Age {
+			fmt.Fprintf(w, "%-8s ", session.AgeString())
+		}
+		fmt.Fprintf(w, "%s %-6s %-6s %s\n",
+			idle,
 			session.JCPU,
 			session.PCPU,
 			session.What,
 		)
+
+		if opts.Tree {
+			displaySessionTree(w, session)
+		}
 	}
 }
 
-func main() {
-	// Retrieve system information
+// displayCompact prints the sessions for --compact: the same columns as
+// displaySessions, separated by single spaces instead of padded to fixed
+// widths. Empty values are shown as "-" so that every line has the same
+// number of fields.
+func displayCompact(w io.Writer, sessions []UserSession, opts options) {
+	_, rows := sessionTable(sessions, opts)
+	for i, row := range rows {
+		fields := make([]string, 0, len(row)+1)
+		if opts.StatusIcons {
+			fields = append(fields, opts.Theme.statusIcon(sessions[i].IdleDuration, opts.IdleWarn, opts.IdleCrit))
+		}
+		for _, cell := range row {
+			text := cell.text
+			if text == "" {
+				text = "-"
+			}
+			fields = append(fields, paint(cell.color, text))
+		}
+		fmt.Fprintln(w, strings.Join(fields, " "))
+
+		if opts.Tree {
+			displaySessionTree(w, sessions[i])
+		}
+	}
+}
+
+// displaySessionTree prints the processes on a session's terminals as a tree
+// under its row.
+func displaySessionTree(w io.Writer, session UserSession) {
+	// With --merge-ttys a row lists several terminals
+	for _, tty := range strings.Split(session.TTY, ",") {
+		procs, err := processesForTTY(tty)
+		if err != nil {
+			logger.Debug("process tree unavailable", "tty", tty, "err", err)
+		}
+		displayProcessTree(w, procs)
+	}
+}
+
+// sessionGroup is a set of sessions sharing a grouping key.
+type sessionGroup struct {
+	Key      string
+	Sessions []UserSession
+}
+
+// groupSessions groups sessions by key, keeping groups in the order their
+// first session appears and sessions in their original order.
+func groupSessions(sessions []UserSession, key func(UserSession) string) []sessionGroup {
+	var groups []sessionGroup
+	index := make(map[string]int)
+	for _, session := range sessions {
+		k := key(session)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, sessionGroup{Key: k})
+		}
+		groups[i].Sessions = append(groups[i].Sessions, session)
+	}
+	return groups
+}
+
+// displayGroups prints each group under a heading with its session count.
+// Sessions with no recorded origin are grouped under "-".
+func displayGroups(w io.Writer, groups []sessionGroup, opts options) {
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		key := group.Key
+		if key == "" {
+			key = "-"
+		}
+		fmt.Fprintf(w, "%s (%s)\n", paint(opts.Theme.From, key), plural(len(group.Sessions), "session", "sessions"))
+		displaySessions(w, group.Sessions, opts)
+	}
+}
+
+// displayTSV prints one tab-separated line per session, in the same column
+// order as displaySessions. Values are never colorized so the output is safe
+// to feed into tools like cut(1).
+func displayTSV(w io.Writer, sessions []UserSession) {
+	for _, session := range sessions {
+		fmt.Fprintln(w, strings.Join([]string{
+			session.User,
+			session.TTY,
+			session.From,
+			session.LoginAt(),
+			session.Idle,
+			session.JCPU,
+			session.PCPU,
+			session.What,
+		}, "\t"))
+	}
+}
+
+// displayJSON prints sessions as a JSON array, with the same fields as the
+// objects passed to --enrich-cmd. With pretty set the array is indented by
+// two spaces.
+func displayJSON(w io.Writer, sessions []UserSession, pretty bool) error {
+	if sessions == nil {
+		sessions = []UserSession{}
+	}
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(sessions)
+}
+
+// systemInfoJSON is the --info-only form of SystemInfo. Values that could not
+// be read are null.
+type systemInfoJSON struct {
+	Time          time.Time `json:"time"`
+	UptimeSeconds *float64  `json:"uptime_seconds"`
+	Load1         *float64  `json:"load1"`
+	Load5         *float64  `json:"load5"`
+	Load15        *float64  `json:"load15"`
+	Users         int       `json:"users"`
+}
+
+// displayInfoJSON prints the system information as a JSON object, for
+// dashboards that poll it more often than the sessions. With pretty set the
+// object is indented by two spaces.
+func displayInfoJSON(w io.Writer, info SystemInfo, pretty bool) error {
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(newSystemInfoJSON(info))
+}
+
+// newSystemInfoJSON converts the system information to its JSON form.
+func newSystemInfoJSON(info SystemInfo) systemInfoJSON {
+	out := systemInfoJSON{Time: info.Time, Users: info.Users}
+	if info.Uptime != "unknown" {
+		out.UptimeSeconds = ptr(info.UptimeDuration.Seconds())
+	}
+	if info.LoadAvg != "unknown" {
+		out.Load1, out.Load5, out.Load15 = ptr(info.Load1), ptr(info.Load5), ptr(info.Load15)
+	}
+	return out
+}
+
+// ptr returns a pointer to a copy of v.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// setupLogging directs debug messages to stderr when verbose is set.
+func setupLogging(verbose bool) {
+	if !verbose {
+		return
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// displayWho prints sessions the way who(1) does: user, terminal, full login
+// date and, for sessions with a known origin, the host in parentheses.
+func displayWho(w io.Writer, sessions []UserSession) {
+	for _, session := range sessions {
+		line := fmt.Sprintf("%-8s %-12s %s", session.User, session.TTY, formatDate(session.LoginTime))
+		if session.From != "" && session.From != "?" {
+			line += " (" + session.From + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// templateData is the value each --format template is executed with. It
+// exposes the session fields alongside the header's system information and
+// the time the output was generated as .Now.
+type templateData struct {
+	UserSession
+	SystemInfo
+	Now time.Time
+}
+
+// displayTemplate executes tmpl once per session, writing each result on its
+// own line.
+func displayTemplate(w io.Writer, tmpl *template.Template, info SystemInfo, sessions []UserSession) error {
+	now := nowFunc()
+	for _, session := range sessions {
+		if err := tmpl.Execute(w, templateData{session, info, now}); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// stringList is a flag.Value collecting the values of a repeatable flag,
+// each of which may also be a comma-separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// usageExamples are shown at the end of --help.
+const usageExamples = `Examples:
+  go-w                          show everyone who is logged in
+  go-w bob --since=1h           show bob's sessions from the last hour
+  go-w --remote --group-by=host count remote sessions per origin
+  go-w --min-idle=2h --age      find stale sessions
+  go-w --json                   print sessions as JSON for scripts
+  go-w --format '{{.User}} {{.From}}'
+                                print a custom line per session
+  go-w --watch=2s               redraw every two seconds
+`
+
+// usage prints the --help text for fs: the synopsis, every flag and some
+// examples.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: %s [options] [user]\n\n", fs.Name())
+	fmt.Fprintln(w, "Show who is logged in and what they are doing. With a user argument,")
+	fmt.Fprintln(w, "only that user's sessions are shown. Options may come before or after it.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
+	fs.PrintDefaults()
+	fmt.Fprintln(w)
+	fmt.Fprint(w, usageExamples)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Exit status is 0 on success, 1 on errors, 2 for invalid usage, 3 on")
+	fmt.Fprintln(w, "unsupported platforms and 4 when --load-threshold is exceeded.")
+}
+
+// parseFlags parses the command-line arguments into options.
+func parseFlags(args []string) (options, error) {
+	fs := flag.NewFlagSet("go-w", flag.ContinueOnError)
+
+	var opts options
+	fs.BoolVar(&opts.TSV, "tsv", false, "print sessions as tab-separated values without a header")
+	fs.BoolVar(&opts.Who, "who", false, "print sessions in who(1) format")
+	fs.BoolVar(&opts.JSON, "json", false, "print sessions as a JSON array")
+	jsonPretty := fs.Bool("json-pretty", false, "print sessions as a JSON array indented for reading; implies --json")
+	fs.BoolVar(&opts.HeaderOnly, "header-only", false, "print only the header line with the uptime, user count and load; with --json, like --info-only")
+	fs.BoolVar(&opts.InfoOnly, "info-only", false, "print only the time, uptime, load averages and user count as a JSON object; indented with --json-pretty")
+	fs.BoolVar(&opts.Table, "table", false, "draw sessions in a bordered table")
+	fs.BoolVar(&opts.Compact, "compact", false, "separate columns by single spaces instead of padding them, for narrow terminals")
+	fs.BoolVar(&opts.NoColor, "no-color", false, "disable colors")
+	fs.BoolVar(&opts.ForceColor, "force-color", false, "use colors even when the output is not a terminal, including with --output")
+	fs.StringVar(&opts.Output, "output", "", "write the output to `file`, replacing its contents, instead of stdout; with --watch it is rewritten on every refresh")
+	fs.BoolVar(&opts.Count, "count", false, "print only the number of sessions")
+	fs.DurationVar(&opts.Since, "since", 0, "show only sessions that logged in within this `duration` (e.g. 30m)")
+	fs.DurationVar(&opts.MinIdle, "min-idle", 0, "show only sessions idle for longer than this `duration` (e.g. 2h)")
+	fs.BoolVar(&opts.Seat, "seat", false, "show the systemd seat of each session")
+	fs.BoolVar(&opts.NProc, "proc-count", false, "show how many processes are attached to each session's terminal")
+	fs.BoolVar(&opts.SID, "session-id", false, "show the kernel session ID of each session, for matching with /proc and logind")
+	fs.BoolVar(&opts.Tree, "tree", false, "list the processes on each session's terminal as a tree under it")
+	fs.BoolVar(&opts.Age, "age", false, "show how long ago each session logged in")
+	fs.BoolVar(&opts.SummaryLine, "summary-line", false, "print the number of sessions and users, and the user with the most sessions, under the sessions")
+	fs.BoolVar(&opts.MergeTTYs, "merge-ttys", false, "show one row per user listing all of their terminals, with the earliest login and least idle time")
+	fs.StringVar(&opts.GroupBy, "group-by", "", "group sessions under a heading per `key` (host)")
+	fs.Var((*stringList)(&opts.ExcludeUsers), "exclude-user", "hide the sessions of this `user`; may be repeated or comma-separated")
+	fs.BoolVar(&opts.Remote, "remote", false, "show only sessions from remote hosts")
+	fs.BoolVar(&opts.Local, "local", false, "show only local sessions")
+	fs.BoolVar(&opts.All, "all", false, "include init and login (getty) processes, reading utmp instead of logind")
+	fs.DurationVar(&opts.Watch, "watch", 0, "redraw the output every `interval` (e.g. 2s) until interrupted")
+	fs.BoolVar(&opts.WatchDiff, "watch-diff", false, "with --watch, highlight sessions that logged in since the last redraw and show those that logged out once more")
+	fs.StringVar(&opts.Serve, "serve", "", "serve the system information and sessions as JSON over HTTP on this `address` (e.g. :8080) until interrupted")
+	fs.DurationVar(&opts.ServeCache, "serve-cache", time.Second, "reuse the sessions gathered for a --serve request for this `duration`; 0 gathers them for every request")
+	fs.BoolVar(&opts.Pager, "pager", false, "pipe the output through $PAGER (default \"less -R\"); enabled automatically when it does not fit on the terminal")
+	fs.IntVar(&opts.Trunc, "trunc", 0, "truncate the FROM column to `N` characters with an ellipsis")
+	fs.IntVar(&opts.UserWidth, "user-width", 0, "make the USER column `N` characters wide, truncating longer names with an ellipsis")
+	noTrunc := fs.Bool("no-trunc", false, "always show FROM in full, overriding --trunc")
+	fs.BoolVar(&opts.IdleAbsolute, "idle-absolute", false, "show the time of last activity, in the --time-format, instead of the idle time")
+	fs.BoolVar(&opts.StatusIcons, "status-icons", false, "mark each session as active, idle or long idle, by --idle-warn and --idle-crit")
+	fs.DurationVar(&opts.IdleWarn, "idle-warn", time.Minute, "idle time at which the IDLE column turns yellow")
+	fs.DurationVar(&opts.IdleCrit, "idle-crit", time.Hour, "idle time at which the IDLE column turns red")
+	timeFormatName := fs.String("time-format", "w", "LOGIN@ format: a preset (w, iso, kitchen) or a Go time `layout`")
+	themeName := fs.String("theme", "dark", "color `theme` of the default output: dark, light or none")
+	format := fs.String("format", "", "print each session using a Go text/template (e.g. '{{.User}} {{.TTY}} {{.Idle}}')")
+	fs.IntVar(&opts.Sample, "sample", 0, "sample the 1-minute load average `N` times, 5s apart, and show its min, max and average")
+	fs.BoolVar(&opts.CPUIdle, "cpu-idle", false, "show the total CPU idle time and the average CPU utilization since boot")
+	fs.Float64Var(&opts.LoadThreshold, "load-threshold", 0, "only check the 1-minute load average, exiting with status 4 if it exceeds this `value`")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "do not print a message when --load-threshold is exceeded")
+	fs.StringVar(&opts.Host, "host", "", "show the sessions of a remote `host` ([user@]server), read over ssh")
+	fs.IntVar(&opts.Retries, "utmp-retries", 3, "read the utmp file up to `N` times if it fails part way, such as on a short read")
+	fs.IntVar(&opts.IDSize, "id-size", 4, "read the ID field of utmp, wtmp and btmp records as `N` bytes, 2 or 4, moving the user, host and exit status after it, for files from older systems")
+	fs.IntVar(&opts.RecordSize, "record-size", 0, "read utmp, wtmp and btmp records of `N` bytes instead of the native size, for files from other systems")
+	fs.StringVar(&opts.Procfs, "procfs", "", "read processes, uptime and load from this procfs `dir` instead of /proc, such as a container's /proc/<pid>/root/proc")
+	fs.StringVar(&opts.UtmpFile, "utmp-file", "", "read sessions from this utmp `file` only, instead of logind, the default utmp locations or /proc")
+	fs.BoolVar(&opts.LastReboot, "last-reboot", false, "print the reboot history from /var/log/wtmp, like 'last reboot'")
+	fs.BoolVar(&opts.Follow, "follow", false, "print logins, logouts and boots from /var/log/wtmp as they happen, until interrupted")
+	fs.BoolVar(&opts.Failed, "failed", false, "print the failed login attempts from /var/log/btmp (requires root), like lastb")
+	fs.BoolVar(&opts.RunLevel, "runlevel", false, "print the current run level from utmp, like 'who -r'")
+	fs.BoolVar(&opts.Dump, "dump", false, "print every record of the utmp file, or of --utmp-file, with its decoded fields")
+	fs.StringVar(&opts.EnrichCmd, "enrich-cmd", "", "pass each session as JSON through this shell `command`, using the session it prints instead")
+	fs.BoolVar(&opts.Numeric, "numeric", false, "show UIDs instead of user names for sessions found in /proc, skipping NSS lookups")
+	fs.BoolVar(&opts.Diag, "diag", false, "print which session sources were tried and what each step found to stderr")
+	fs.BoolVar(&opts.Verify, "verify", false, "compare the sessions found with those listed by the system w, printing any differences to stderr")
+	fs.BoolVar(&opts.Verbose, "v", false, "log debug messages to stderr")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "log debug messages to stderr")
+	fs.Usage = func() { usage(fs) }
+
+	// Flags may come before or after the user argument, as in
+	// "go-w bob --since=1h", until a "--", after which everything is an
+	// argument, as in "go-w -- -v"
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return options{}, err
+		}
+		if parsed := len(args) - fs.NArg(); parsed > 0 && args[parsed-1] == "--" {
+			positional = append(positional, fs.Args()...)
+			break
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) > 0 {
+		opts.User = positional[0]
+	}
+
+	// Report invalid combinations the same way the flag package reports
+	// parse errors
+	fail := func(format string, a ...any) (options, error) {
+		err := fmt.Errorf(format, a...)
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return options{}, err
+	}
+
+	if len(positional) > 1 {
+		return fail("too many arguments: %s", strings.Join(positional, " "))
+	}
+	if opts.GroupBy != "" && opts.GroupBy != "host" {
+		return fail("invalid --group-by %q: must be host", opts.GroupBy)
+	}
+	if opts.Remote && opts.Local {
+		return fail("--remote and --local are mutually exclusive")
+	}
+	if opts.NoColor && opts.ForceColor {
+		return fail("--no-color and --force-color are mutually exclusive")
+	}
+	if opts.Since < 0 {
+		return fail("--since must not be negative")
+	}
+	if opts.MinIdle < 0 {
+		return fail("--min-idle must not be negative")
+	}
+	if opts.Watch < 0 {
+		return fail("--watch must not be negative")
+	}
+	if opts.WatchDiff && opts.Watch == 0 {
+		return fail("--watch-diff requires --watch")
+	}
+	if opts.ServeCache < 0 {
+		return fail("--serve-cache must not be negative")
+	}
+	if opts.Trunc < 0 {
+		return fail("--trunc must not be negative")
+	}
+	if opts.UserWidth < 0 || (opts.UserWidth > 0 && opts.UserWidth < len("USER")) {
+		return fail("--user-width must be at least 4, the width of the USER heading")
+	}
+	if *noTrunc {
+		opts.Trunc = 0
+	}
+	if *jsonPretty {
+		opts.JSON, opts.Pretty = true, true
+	}
+	if opts.HeaderOnly && (opts.Count || opts.TSV || opts.Who || *format != "") {
+		return fail("--header-only cannot be combined with --count, --tsv, --who or --format")
+	}
+	if opts.HeaderOnly && opts.JSON {
+		opts.InfoOnly = true
+	}
+	if opts.Sample < 0 {
+		return fail("--sample must not be negative")
+	}
+	if opts.Sample > 0 && opts.Host != "" {
+		return fail("--sample cannot be combined with --host")
+	}
+	if opts.Retries < 1 {
+		return fail("--utmp-retries must be at least 1")
+	}
+	if opts.RecordSize < 0 {
+		return fail("--record-size must not be negative")
+	}
+	if opts.IDSize != 2 && opts.IDSize != 4 {
+		return fail("invalid --id-size %d: must be 2 or 4", opts.IDSize)
+	}
+	if opts.LoadThreshold < 0 {
+		return fail("--load-threshold must not be negative")
+	}
+	if opts.IdleWarn > opts.IdleCrit {
+		return fail("--idle-warn must not exceed --idle-crit")
+	}
+	layout, err := parseTimeFormat(*timeFormatName)
+	if err != nil {
+		return fail("%v", err)
+	}
+	opts.TimeFormat = layout
+	if opts.Theme, err = lookupTheme(*themeName); err != nil {
+		return fail("invalid --theme: %v", err)
+	}
+	if *format != "" {
+		tmpl, err := template.New("format").Parse(*format)
+		if err != nil {
+			return fail("invalid --format: %v", err)
+		}
+		opts.Format = tmpl
+	}
+
+	return opts, nil
+}
+
+// run executes the program with the given arguments, writing its output to
+// stdout, and returns the exit code.
+func run(args []string, stdout io.Writer) int {
+	opts, err := parseFlags(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage // parseFlags has already printed the error and usage
+	}
+	setupLogging(opts.Verbose)
+
+	if runtime.GOOS != "linux" {
+		fmt.Fprintf(os.Stderr, "go-w: unsupported platform %s\n", runtime.GOOS)
+		return exitUnsupported
+	}
+
+	timeFormat = opts.TimeFormat
+	numericUsers = opts.Numeric
+	utmpRetries = opts.Retries
+	setRecordLayout(utmpLayout{IDSize: opts.IDSize})
+	if opts.RecordSize > 0 {
+		recordSize = opts.RecordSize
+	}
+	if opts.NoColor {
+		color.NoColor = true
+	}
+	utmpFileOnly = opts.UtmpFile != ""
+	if utmpFileOnly {
+		utmpPaths = []string{opts.UtmpFile}
+	}
+	if opts.Procfs != "" {
+		setProcfs(opts.Procfs)
+	}
+	if needsProcfs(opts) && !procfsMounted() {
+		// Before gathering anything, rather than a cascade of errors for each
+		// file under it
+		log.Printf("Error: %s is not mounted; go-w requires procfs", procPath)
+		return exitUnsupported
+	}
+
+	if opts.All {
+		sessionTypes[INIT_PROCESS] = true
+		sessionTypes[LOGIN_PROCESS] = true
+	}
+
+	if opts.ForceColor {
+		color.NoColor = false
+	}
+	if opts.Output != "" {
+		return runToFile(opts)
+	}
+	return execute(opts, stdout)
+}
+
+// needsProcfs reports whether the mode selected by opts reads the local
+// procfs. Remote sessions and the modes that only read wtmp, btmp or utmp
+// records work without it.
+func needsProcfs(opts options) bool {
+	return opts.Host == "" && !opts.Dump && !opts.LastReboot && !opts.Failed && !opts.RunLevel && !opts.Follow
+}
+
+// runToFile executes the program with its output going to opts.Output, and
+// returns the exit code. Colors are disabled unless forced.
+func runToFile(opts options) int {
+	out, err := createOutput(opts.Output)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	if !opts.ForceColor {
+		color.NoColor = true
+	}
+
+	code := execute(opts, out)
+	if err := out.Close(); err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	return code
+}
+
+// execute runs the mode selected by opts, once the global settings have been
+// applied, and returns the exit code.
+func execute(opts options, stdout io.Writer) int {
+	if opts.LoadThreshold > 0 {
+		return checkLoad(stdout, opts)
+	}
+	if opts.LastReboot {
+		return lastReboot(stdout)
+	}
+	if opts.Failed {
+		return failedLogins(stdout)
+	}
+	if opts.RunLevel {
+		return printRunLevel(stdout)
+	}
+	if opts.Dump {
+		return dumpUtmpFile(stdout)
+	}
+	if opts.Follow {
+		return follow(stdout)
+	}
+
+	if opts.Sample > 0 {
+		// Once, rather than blocking every --watch redraw or --serve request
+		var err error
+		if loadSamples, err = sampleLoad(opts.Sample, loadSampleInterval); err != nil {
+			log.Printf("Warning: failed to sample load average: %v", err)
+		}
+	}
+
+	if opts.Serve != "" {
+		return serve(opts)
+	}
+	if opts.Watch > 0 {
+		return watch(stdout, opts)
+	}
+
+	if opts.Verify {
+		// Compare before filtering, against everything the system w lists
+		if sessions, _, err := parseUtmp(); err == nil {
+			verifySessions(os.Stderr, sessions)
+		} else {
+			log.Printf("Warning: --verify: %v", err)
+		}
+	}
+
+	if opts.Diag {
+		diag = &diagnostics{}
+	}
+	info, sessions, method, err := gather(opts)
+	if opts.Diag {
+		diag.write(os.Stderr)
+	}
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+
+	var output bytes.Buffer
+	if err := render(&output, opts, info, sessions, method); err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+
+	// Page the output if requested, or if it would not fit on the terminal.
+	// The rendered lines are counted, as --tree and the group headings add
+	// lines of their own to the sessions'.
+	if !opts.Count && !opts.InfoOnly && !opts.HeaderOnly && opts.Output == "" && (opts.Pager || exceedsTerminal(stdout, bytes.Count(output.Bytes(), []byte("\n")))) {
+		p, err := startPager(stdout)
+		if err == nil {
+			defer p.Close()
+			// Writes fail once the user quits the pager, which is fine
+			output.WriteTo(p)
+			return exitOK
+		}
+		logger.Debug("pager unavailable", "err", err)
+	}
+
+	if _, err := output.WriteTo(stdout); err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// checkLoad compares the 1-minute load average with opts.LoadThreshold for
+// use from cron. It returns exitLoadHigh, after printing a message unless
+// opts.Quiet is set, when the load exceeds the threshold.
+func checkLoad(w io.Writer, opts options) int {
+	load, err := readLoadAverage()
+	if err != nil {
+		log.Printf("Error: failed to read load average: %v", err)
+		return exitError
+	}
+	if load.values[0] <= opts.LoadThreshold {
+		return exitOK
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(w, "load average %.2f exceeds threshold %.2f\n", load.values[0], opts.LoadThreshold)
+	}
+	return exitLoadHigh
+}
+
+// gather collects the system information and the user sessions selected by
+// opts, along with the method string naming where the sessions came from.
+func gather(opts options) (SystemInfo, []UserSession, string, error) {
+	var info SystemInfo
+	var sessions []UserSession
+	var method string
+	var err error
+	if opts.Host != "" {
+		info, sessions, method, err = gatherRemote(opts.Host)
+	} else {
+		info, sessions, method, err = gatherLocal()
+	}
+	if err != nil {
+		return info, nil, "", err
+	}
+	info.Users = len(sessions)
+	info.LoadSamples = loadSamples
+
+	if opts.CPUIdle && opts.Host == "" {
+		if uptime, idle, err := readUptimeFull(); err != nil {
+			log.Printf("Warning: failed to read CPU idle time: %v", err)
+		} else {
+			info.CPUs = onlineCPUs()
+			info.CPUIdle = idle
+			info.CPUUtil = cpuUtilization(uptime, idle, info.CPUs)
+		}
+	}
+
+	if opts.NProc && opts.Host == "" {
+		if err := countProcesses(sessions); err != nil {
+			log.Printf("Warning: failed to count processes: %v", err)
+		}
+	}
+
+	if opts.EnrichCmd != "" {
+		runEnrichCmd(opts.EnrichCmd, sessions)
+	}
+
+	if opts.IdleAbsolute {
+		now := nowFunc()
+		for i := range sessions {
+			sessions[i].Idle = idleSince(sessions[i], now)
+		}
+	}
+
+	// Apply the session filters
+	if opts.User != "" {
+		sessions = filterSessions(sessions, func(s UserSession) bool { return s.User == opts.User })
+	}
+	if len(opts.ExcludeUsers) > 0 {
+		sessions = filterSessions(sessions, func(s UserSession) bool { return !slices.Contains(opts.ExcludeUsers, s.User) })
+	}
+	if opts.Since > 0 {
+		cutoff := nowFunc().Add(-opts.Since)
+		sessions = filterSessions(sessions, func(s UserSession) bool { return loggedInSince(s, cutoff) })
+	}
+	if opts.MinIdle > 0 {
+		sessions = filterSessions(sessions, func(s UserSession) bool { return idleLongerThan(s, opts.MinIdle) })
+	}
+	if opts.Remote {
+		sessions = filterSessions(sessions, isRemote)
+	} else if opts.Local {
+		sessions = filterSessions(sessions, func(s UserSession) bool { return !isRemote(s) })
+	}
+
+	if opts.MergeTTYs {
+		sessions = mergeTTYs(sessions)
+	}
+
+	diag.filtered(len(sessions))
+	return info, sessions, method, nil
+}
+
+// gatherLocal collects the system information and sessions of this machine.
+func gatherLocal() (SystemInfo, []UserSession, string, error) {
+	// Retrieve system information; missing fields are not fatal
 	info, err := getSystemInfo()
 	if err != nil {
-		log.Fatalf("Error: %v", err)
+		log.Printf("Warning: %v", err)
 	}
 
 	// Parse user sessions
 	sessions, method, err := parseUtmp()
 	if err != nil {
-		log.Fatalf("Error: %v", err)
+		return info, nil, "", err
 	}
 
-	// Display the output with colors
-	displayHeader(info, method)
-	displaySessions(sessions)
+	if isContainer() {
+		method += " (container)"
+	}
+
+	enrichSessions(sessions)
+	diag.enriched(sessions)
+	return info, sessions, method, nil
+}
+
+// render writes the sessions in the output format selected by opts.
+func render(w io.Writer, opts options, info SystemInfo, sessions []UserSession, method string) error {
+	// Machine-readable formats print nothing at all for no sessions, except
+	// JSON, which prints an empty array
+	switch {
+	case opts.InfoOnly:
+		return displayInfoJSON(w, info, opts.Pretty)
+	case opts.HeaderOnly:
+		displaySummary(w, info, method, opts.Theme)
+		return nil
+	case opts.Count:
+		fmt.Fprintln(w, len(sessions))
+		return nil
+	case opts.TSV:
+		displayTSV(w, sessions)
+		return nil
+	case opts.Who:
+		displayWho(w, sessions)
+		return nil
+	case opts.JSON:
+		return displayJSON(w, sessions, opts.Pretty)
+	case opts.Format != nil:
+		return displayTemplate(w, opts.Format, info, sessions)
+	}
+
+	switch {
+	case opts.Table:
+		displaySummary(w, info, method, opts.Theme)
+		displayTable(w, sessions, opts)
+	case opts.GroupBy == "host":
+		displayHeader(w, info, method, opts)
+		displayGroups(w, groupSessions(sessions, func(s UserSession) string { return s.From }), opts)
+	default:
+		// Display the output with colors
+		displayHeader(w, info, method, opts)
+		displaySessions(w, sessions, opts)
+	}
+
+	// An empty listing under the header can look like a bug
+	if len(sessions) == 0 {
+		fmt.Fprintln(w, paint(opts.Theme.Note, "no users logged in"))
+	}
+	if opts.SummaryLine {
+		displaySummaryLine(w, sessions, opts.Theme)
+	}
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
 }
@@ -1,10 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
 )
 
 // TestFormatDuration tests the formatDuration function.
@@ -58,13 +77,23 @@ func TestParseUtmp(t *testing.T) {
 	mockUtmpData := make([]byte, binary.Size(utmp{})) // Create a byte slice of the correct size
 
 	// Fill in the fields
-	binary.LittleEndian.PutUint16(mockUtmpData[0:2], 7)                      // Type = 7 (USER_PROCESS)
+	binary.LittleEndian.PutUint16(mockUtmpData[0:2], USER_PROCESS)           // Type = USER_PROCESS
 	binary.LittleEndian.PutUint32(mockUtmpData[4:8], 123)                    // Pid = 123
 	copy(mockUtmpData[8:40], []byte("tty1\x00"))                             // Line = "tty1"
 	copy(mockUtmpData[40:44], []byte("id1\x00"))                             // ID = "id1"
 	copy(mockUtmpData[44:76], []byte("user1\x00"))                           // User = "user1"
 	copy(mockUtmpData[76:332], []byte("host1\x00"))                          // Host = "host1"
-	binary.LittleEndian.PutUint64(mockUtmpData[332:340], uint64(1672502400)) // Time = 2023-01-01 00:00:00 UTC
+	binary.LittleEndian.PutUint32(mockUtmpData[340:344], uint32(1672502400)) // TimeSec = 2022-12-31 16:00:00 UTC
+	binary.LittleEndian.PutUint32(mockUtmpData[344:348], 123456)             // TimeUsec must not affect the time
+
+	// Parse the mock data directly from memory
+	sessions, err := parseUtmpReader(bytes.NewReader(mockUtmpData))
+	if err != nil {
+		t.Fatalf("parseUtmpReader failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session from reader, got %d", len(sessions))
+	}
 
 	// Write mock data to a temporary file
 	tmpFile, err := os.CreateTemp("", "utmp")
@@ -78,24 +107,33 @@ func TestParseUtmp(t *testing.T) {
 	}
 	tmpFile.Close()
 
-	// Override the utmp path for testing
-	oldUtmpPath := utmpPath
-	utmpPath = tmpFile.Name()
+	// Override the utmp paths for testing, with a missing first candidate,
+	// and make sure logind and the processes on this host are not used
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldProcPath := procPath
+	utmpPaths = []string{tmpFile.Name() + ".missing", tmpFile.Name()}
+	logindSessionsDir = tmpFile.Name() + ".missing"
+	procPath = t.TempDir()
 	defer func() {
-		utmpPath = oldUtmpPath
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		procPath = oldProcPath
 	}()
 
 	// Parse the mock utmp file
-	sessions, method, err := parseUtmp()
+	fileSessions, method, err := parseUtmp()
 	if err != nil {
 		t.Fatalf("parseUtmp failed: %v", err)
 	}
-
-	// Verify the parsed data
-	if len(sessions) != 1 {
-		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	if len(fileSessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(fileSessions))
+	}
+	if fileSessions[0] != sessions[0] {
+		t.Errorf("Expected file and reader sessions to match, got %+v and %+v", fileSessions[0], sessions[0])
 	}
 
+	// Verify the parsed data
 	session := sessions[0]
 	if session.User != "user1" {
 		t.Errorf("Expected user 'user1', got '%s'", session.User)
@@ -106,11 +144,14 @@ func TestParseUtmp(t *testing.T) {
 	if session.From != "host1" {
 		t.Errorf("Expected host 'host1', got '%s'", session.From)
 	}
-	if session.LoginAt != "00:00" {
-		t.Errorf("Expected login time '00:00', got '%s'", session.LoginAt)
+	if !session.LoginTime.Equal(time.Unix(1672502400, 123456000)) {
+		t.Errorf("Expected login timestamp %v, got %v", time.Unix(1672502400, 123456000), session.LoginTime)
 	}
-	if method != "using /var/run/utmp" {
-		t.Errorf("Expected method 'using /var/run/utmp', got '%s'", method)
+	if session.LoginAt() != "16:00" {
+		t.Errorf("Expected login time '16:00', got '%s'", session.LoginAt())
+	}
+	if method != "using "+tmpFile.Name() {
+		t.Errorf("Expected method 'using %s', got '%s'", tmpFile.Name(), method)
 	}
 }
 
@@ -152,6 +193,8 @@ func TestGetSystemInfo(t *testing.T) {
 		loadAvgPath = oldLoadAvgPath
 	}()
 
+	setNow(t, time.Date(2023, 1, 1, 9, 30, 5, 0, time.UTC))
+
 	// Call getSystemInfo
 	info, err := getSystemInfo()
 	if err != nil {
@@ -159,6 +202,10 @@ func TestGetSystemInfo(t *testing.T) {
 	}
 
 	// Verify the results
+	if info.CurrentTime != "09:30:05" {
+		t.Errorf("Expected current time '09:30:05', got '%s'", info.CurrentTime)
+	}
+
 	expectedUptime := "3:25:45"
 	if info.Uptime != expectedUptime {
 		t.Errorf("Expected uptime '%s', got '%s'", expectedUptime, info.Uptime)
@@ -168,4 +215,1752 @@ func TestGetSystemInfo(t *testing.T) {
 	if info.LoadAvg != expectedLoadAvg {
 		t.Errorf("Expected load average '%s', got '%s'", expectedLoadAvg, info.LoadAvg)
 	}
+	if info.Load1 != 0.15 || info.Load5 != 0.10 || info.Load15 != 0.05 {
+		t.Errorf("Expected loads 0.15 0.10 0.05, got %v %v %v", info.Load1, info.Load5, info.Load15)
+	}
+}
+
+// TestDisplayTSV tests that displayTSV emits plain tab-separated rows.
+func TestDisplayTSV(t *testing.T) {
+	// Force color on to make sure it does not leak into TSV output
+	oldNoColor := color.NoColor
+	color.NoColor = false
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	sessions := []UserSession{
+		{User: "user1", TTY: "tty1", From: "host1", LoginTime: time.Unix(1672531200, 0), Idle: ".", JCPU: "0.00s", PCPU: "0.00s", What: "-"},
+	}
+
+	var buf bytes.Buffer
+	displayTSV(&buf, sessions)
+
+	expected := "user1\ttty1\thost1\t00:00\t.\t0.00s\t0.00s\t-\n"
+	if buf.String() != expected {
+		t.Errorf("Expected TSV output %q, got %q", expected, buf.String())
+	}
+	if strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("TSV output contains ANSI escape codes: %q", buf.String())
+	}
+}
+
+// addrFromIP builds a utmp Addr field holding the given IP address, with the
+// address bytes laid out in network order as they are on disk.
+func addrFromIP(s string) [4]int32 {
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	var addr [4]int32
+	for i := 0; i < len(ip)/4; i++ {
+		addr[i] = int32(binary.LittleEndian.Uint32(ip[i*4:]))
+	}
+	return addr
+}
+
+// TestAddrFamily tests the addrFamily heuristic and the matching formatters.
+func TestAddrFamily(t *testing.T) {
+	tests := []struct {
+		addr           [4]int32
+		expectedFamily int
+		expectedAddr   string
+	}{
+		{[4]int32{0, 0, 0, 0}, syscall.AF_UNSPEC, ""},
+		{addrFromIP("192.168.1.100"), syscall.AF_INET, "192.168.1.100"},
+		{addrFromIP("2001:db8::1"), syscall.AF_INET6, "2001:db8::1"},
+		// A word as decodeUtmp reads it, so that addrFromIP cannot hide a
+		// byte-order mistake it shares with formatAddr
+		{[4]int32{0x04030201, 0, 0, 0}, syscall.AF_INET, "1.2.3.4"},
+	}
+
+	for _, test := range tests {
+		family := addrFamily(test.addr)
+		if family != test.expectedFamily {
+			t.Errorf("addrFamily(%v) = %v; expected %v", test.addr, family, test.expectedFamily)
+		}
+
+		result := formatAddr(test.addr)
+		if result != test.expectedAddr {
+			t.Errorf("formatAddr(%v) = %q; expected %q", test.addr, result, test.expectedAddr)
+		}
+	}
+}
+
+// TestFormatAddrByteOrder tests that addresses stored on disk in network
+// byte order are formatted in that order, not reversed.
+func TestFormatAddrByteOrder(t *testing.T) {
+	tests := []struct {
+		stored   []byte
+		expected string
+	}{
+		{[]byte{1, 2, 3, 4}, "1.2.3.4"},
+		{[]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		record := make([]byte, utmpSize)
+		copy(record[348:], test.stored)
+		var entry utmp
+		decodeUtmp(record, &entry)
+		if result := formatAddr(entry.Addr); result != test.expected {
+			t.Errorf("formatAddr of % x = %q; expected %q", test.stored, result, test.expected)
+		}
+	}
+}
+
+// TestIsRemote tests the isRemote classification.
+func TestIsRemote(t *testing.T) {
+	tests := []struct {
+		from     string
+		expected bool
+	}{
+		{"192.168.1.100", true},
+		{"server.example.com", true},
+		{"", false},
+		{"?", false},
+		{":0", false},
+		{":1.0", false},
+	}
+
+	for _, test := range tests {
+		result := isRemote(UserSession{From: test.from})
+		if result != test.expected {
+			t.Errorf("isRemote(%q) = %v; expected %v", test.from, result, test.expected)
+		}
+	}
+}
+
+// TestDescribeWhat tests labelling graphical sessions in the WHAT column, by
+// their command or that of their leader process.
+func TestDescribeWhat(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	defer func() { procPath = oldProcPath }()
+	mockProcess(t, dir, 300, 1000, 0, "/usr/libexec/gdm-wayland-session\x00/usr/bin/gnome-session\x00")
+	mockProcess(t, dir, 400, 1000, 34816, "-bash\x00")
+
+	tests := []struct {
+		session  UserSession
+		expected string
+	}{
+		{UserSession{TTY: "tty2", What: "/usr/libexec/gdm-wayland-session /usr/bin/gnome-session"}, "graphical session"},
+		{UserSession{TTY: "tty2", What: "gnome-session-binary --session=ubuntu"}, "graphical session"},
+		{UserSession{TTY: ":0", What: "-"}, "graphical session"},
+		{UserSession{TTY: "tty1", From: ":0", What: "-"}, "graphical session"},
+		{UserSession{TTY: "pts/0", From: "10.0.0.1", What: "-"}, "-"},
+		{UserSession{TTY: ":0", What: "vim notes.txt"}, "vim notes.txt"},
+		{UserSession{TTY: "tty1", What: "LOGIN_PROCESS"}, "LOGIN_PROCESS"},
+		{UserSession{TTY: "tty2", What: "-", Session: 300}, "graphical session"},
+		{UserSession{TTY: "pts/0", What: "-", Session: 400}, "-"},
+		{UserSession{TTY: "pts/1", What: "-", Session: 500}, "-"},
+	}
+
+	for _, test := range tests {
+		if result := describeWhat(test.session); result != test.expected {
+			t.Errorf("describeWhat(%+v) = %q; expected %q", test.session, result, test.expected)
+		}
+	}
+}
+
+// mockUtmpRecord builds a raw utmp record with the given fields set.
+func mockUtmpRecord(typ int16, line, user, host string, sec int64) []byte {
+	record := make([]byte, binary.Size(utmp{}))
+	binary.LittleEndian.PutUint16(record[0:2], uint16(typ))
+	copy(record[8:40], line)
+	copy(record[44:76], user)
+	copy(record[76:332], host)
+	binary.LittleEndian.PutUint32(record[340:344], uint32(sec))
+	return record
+}
+
+// encodeUtmp encodes a utmp record in the little-endian on-disk layout read
+// by decodeUtmp.
+func encodeUtmp(entry utmp) []byte {
+	b := make([]byte, utmpSize)
+	le := binary.LittleEndian
+	le.PutUint16(b[0:], uint16(entry.Type))
+	le.PutUint32(b[4:], uint32(entry.Pid))
+	copy(b[8:40], entry.Line[:])
+	copy(b[40:44], entry.ID[:])
+	copy(b[44:76], entry.User[:])
+	copy(b[76:332], entry.Host[:])
+	le.PutUint16(b[332:], uint16(entry.Exit.Termination))
+	le.PutUint16(b[334:], uint16(entry.Exit.Exit))
+	le.PutUint32(b[336:], uint32(entry.Session))
+	le.PutUint32(b[340:], uint32(entry.TimeSec))
+	le.PutUint32(b[344:], uint32(entry.TimeUsec))
+	for i, word := range entry.Addr {
+		le.PutUint32(b[348+i*4:], uint32(word))
+	}
+	copy(b[364:384], entry.Unused[:])
+	return b
+}
+
+// setNow fixes the time nowFunc returns for the rest of the test.
+func setNow(t *testing.T, now time.Time) {
+	t.Helper()
+
+	oldNowFunc := nowFunc
+	nowFunc = func() time.Time { return now }
+	t.Cleanup(func() { nowFunc = oldNowFunc })
+}
+
+// writeTempFile writes data to a temporary file and returns its path.
+func writeTempFile(t *testing.T, pattern string, data []byte) string {
+	t.Helper()
+
+	tmpFile, err := os.CreateTemp("", pattern)
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+
+	if _, err := tmpFile.Write(data); err != nil {
+		t.Fatalf("Failed to write mock data: %v", err)
+	}
+	tmpFile.Close()
+
+	return tmpFile.Name()
+}
+
+// TestParseUtmpGraphicalLogin tests that X display logins keep ":0" as their
+// origin and are never treated as terminal devices.
+func TestParseUtmpGraphicalLogin(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(USER_PROCESS, ":0", "alice", ":0", 1672502400)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, ":1", "bob", "", 1672502400)...)
+
+	sessions, err := parseUtmpFile(writeTempFile(t, "utmp", data))
+	if err != nil {
+		t.Fatalf("parseUtmpFile failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	for i, expected := range []string{":0", ":1"} {
+		if sessions[i].From != expected {
+			t.Errorf("Expected host '%s', got '%s'", expected, sessions[i].From)
+		}
+		if _, ok := ttyDevicePath(sessions[i].TTY); ok {
+			t.Errorf("Expected %q not to map to a device path", sessions[i].TTY)
+		}
+	}
+
+	enrichSessions(sessions)
+	for _, session := range sessions {
+		if session.Idle != "?" {
+			t.Errorf("Expected idle '?' for display %s, got '%s'", session.TTY, session.Idle)
+		}
+	}
+}
+
+// TestFormatIdle tests the formatIdle function.
+func TestFormatIdle(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{1500 * time.Millisecond, "1.50s"},
+		{5*time.Minute + 7*time.Second, "5:07"},
+		{3*time.Hour + 4*time.Minute, "3:04m"},
+		{72 * time.Hour, "3days"},
+	}
+
+	for _, test := range tests {
+		result := formatIdle(test.duration)
+		if result != test.expected {
+			t.Errorf("formatIdle(%v) = %v; expected %v", test.duration, result, test.expected)
+		}
+	}
+}
+
+// TestParseLoadAverage tests parsing /proc/loadavg, including load averages
+// written with decimal commas, which are displayed as read.
+func TestParseLoadAverage(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected [3]float64
+		text     string
+		wantErr  bool
+	}{
+		{"0.15 0.10 0.05 1/100 12345\n", [3]float64{0.15, 0.10, 0.05}, "0.15 0.10 0.05", false},
+		{"0,15 0,10 0,05 1/100 12345\n", [3]float64{0.15, 0.10, 0.05}, "0,15 0,10 0,05", false},
+		{"12,50 3.25 1,00\n", [3]float64{12.5, 3.25, 1}, "12,50 3.25 1,00", false},
+		{"0,1,5 0.10 0.05\n", [3]float64{}, "", true},
+		{"0.15 0.10\n", [3]float64{}, "", true},
+	}
+
+	for _, test := range tests {
+		load, err := parseLoadAverage(test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseLoadAverage(%q) error = %v; expected error: %v", test.data, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (load.values != test.expected || load.text != test.text) {
+			t.Errorf("parseLoadAverage(%q) = %v %q; expected %v %q", test.data, load.values, load.text, test.expected, test.text)
+		}
+	}
+}
+
+// TestReadUptimeSysinfo tests falling back to sysinfo(2) when /proc/uptime
+// cannot be read, unless the procfs is another system's.
+func TestReadUptimeSysinfo(t *testing.T) {
+	oldUptimePath := uptimePath
+	uptimePath = filepath.Join(t.TempDir(), "uptime")
+	defer func() {
+		uptimePath = oldUptimePath
+	}()
+
+	uptime, err := readUptime()
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Errorf("Expected an error without /proc/uptime, got uptime %v", uptime)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("readUptime failed: %v", err)
+	}
+	if uptime <= 0 || uptime%time.Second != 0 {
+		t.Errorf("Expected a whole number of seconds from sysinfo, got %v", uptime)
+	}
+
+	// Not for another system's procfs
+	foreignProcfs = true
+	defer func() { foreignProcfs = false }()
+	if _, err := readUptime(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the missing file to be reported with --procfs, got %v", err)
+	}
+}
+
+// TestGetSystemInfoEmptyFiles tests that empty /proc/uptime and /proc/loadavg
+// files, as found in some minimal containers, are reported rather than
+// crashing.
+func TestGetSystemInfoEmptyFiles(t *testing.T) {
+	oldUptimePath := uptimePath
+	oldLoadAvgPath := loadAvgPath
+	uptimePath = writeTempFile(t, "uptime", nil)
+	loadAvgPath = writeTempFile(t, "loadavg", []byte("\n"))
+	defer func() {
+		uptimePath = oldUptimePath
+		loadAvgPath = oldLoadAvgPath
+	}()
+
+	info, err := getSystemInfo()
+	if err == nil || !strings.Contains(err.Error(), "empty uptime file") {
+		t.Errorf("Expected an empty uptime file warning, got %v", err)
+	}
+	if info.Uptime != "unknown" || info.LoadAvg != "unknown" {
+		t.Errorf("Expected unknown uptime and load average, got '%s' and '%s'", info.Uptime, info.LoadAvg)
+	}
+}
+
+// TestGetSystemInfoMissingLoadAvg tests that a missing /proc/loadavg only
+// produces a warning and leaves the other fields populated.
+func TestGetSystemInfoMissingLoadAvg(t *testing.T) {
+	oldUptimePath := uptimePath
+	oldLoadAvgPath := loadAvgPath
+	uptimePath = writeTempFile(t, "uptime", []byte("12345.67 23456.78\n"))
+	loadAvgPath = uptimePath + ".missing"
+	defer func() {
+		uptimePath = oldUptimePath
+		loadAvgPath = oldLoadAvgPath
+	}()
+
+	info, err := getSystemInfo()
+	if err == nil {
+		t.Errorf("Expected a warning for the missing load average")
+	}
+	if info.Uptime != "3:25:45" {
+		t.Errorf("Expected uptime '3:25:45', got '%s'", info.Uptime)
+	}
+	if info.LoadAvg != "unknown" {
+		t.Errorf("Expected load average 'unknown', got '%s'", info.LoadAvg)
+	}
+}
+
+// TestParseUtmpAllTypes tests that login processes are only reported once
+// their type is added to sessionTypes, and are labelled in WHAT.
+func TestParseUtmpAllTypes(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "user1", "host1", 1672502400)...)
+	data = append(data, mockUtmpRecord(LOGIN_PROCESS, "tty1", "LOGIN", "", 1672502400)...)
+	path := writeTempFile(t, "utmp", data)
+
+	sessions, err := parseUtmpFile(path)
+	if err != nil {
+		t.Fatalf("parseUtmpFile failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session by default, got %d", len(sessions))
+	}
+
+	sessionTypes[LOGIN_PROCESS] = true
+	defer delete(sessionTypes, LOGIN_PROCESS)
+
+	sessions, err = parseUtmpFile(path)
+	if err != nil {
+		t.Fatalf("parseUtmpFile failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions with login processes, got %d", len(sessions))
+	}
+	if sessions[0].What != "-" {
+		t.Errorf("Expected WHAT '-' for user process, got '%s'", sessions[0].What)
+	}
+	if sessions[1].What != "LOGIN_PROCESS" {
+		t.Errorf("Expected WHAT 'LOGIN_PROCESS', got '%s'", sessions[1].What)
+	}
+}
+
+// TestTTYDevicePath tests that only plain terminal names map to devices.
+func TestTTYDevicePath(t *testing.T) {
+	tests := []struct {
+		tty      string
+		expected string
+		ok       bool
+	}{
+		{"tty1", "/dev/tty1", true},
+		{"ttyS0", "/dev/ttyS0", true},
+		{"pts/0", "/dev/pts/0", true},
+		{"console", "/dev/console", true},
+		{"", "", false},
+		{"?", "", false},
+		{":0", "", false},
+		{"../etc/passwd", "", false},
+		{"pts/../../etc/passwd", "", false},
+		{"tty1/../../etc/shadow", "", false},
+		{"/etc/passwd", "", false},
+		{"pts/", "", false},
+		{"..", "", false},
+	}
+
+	for _, test := range tests {
+		result, ok := ttyDevicePath(test.tty)
+		if result != test.expected || ok != test.ok {
+			t.Errorf("ttyDevicePath(%q) = %q, %v; expected %q, %v", test.tty, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+// TestIdleColor tests the idle time thresholds.
+func TestIdleColor(t *testing.T) {
+	tests := []struct {
+		idle     time.Duration
+		expected *color.Color
+	}{
+		{idleUnknown, nil},
+		{30 * time.Second, color.New(color.FgGreen)},
+		{10 * time.Minute, color.New(color.FgYellow)},
+		{2 * time.Hour, color.New(color.FgRed)},
+	}
+
+	for _, test := range tests {
+		result := themes["dark"].idleColor(test.idle, time.Minute, time.Hour)
+		if (result == nil) != (test.expected == nil) || (result != nil && !result.Equals(test.expected)) {
+			t.Errorf("idleColor(%v) = %v; expected %v", test.idle, result, test.expected)
+		}
+	}
+}
+
+// TestDisplayTemplate tests rendering sessions with a --format template.
+func TestDisplayTemplate(t *testing.T) {
+	opts, err := parseFlags([]string{"--format", "{{.User}} {{.TTY}} {{.Idle}} {{.LoadAvg}}"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+
+	info := SystemInfo{LoadAvg: "0.15 0.10 0.05"}
+	sessions := []UserSession{
+		{User: "user1", TTY: "tty1", Idle: "1.00s"},
+		{User: "user2", TTY: "pts/0", Idle: "5:07"},
+	}
+
+	var buf bytes.Buffer
+	if err := displayTemplate(&buf, opts.Format, info, sessions); err != nil {
+		t.Fatalf("displayTemplate failed: %v", err)
+	}
+
+	expected := "user1 tty1 1.00s 0.15 0.10 0.05\nuser2 pts/0 5:07 0.15 0.10 0.05\n"
+	if buf.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, buf.String())
+	}
+}
+
+// TestDisplayWho tests the who(1) compatible output.
+func TestDisplayWho(t *testing.T) {
+	sessions := []UserSession{
+		{User: "user1", TTY: "tty1", From: "", LoginTime: time.Unix(1672502400, 0)},
+		{User: "user2", TTY: "pts/0", From: "192.168.1.100", LoginTime: time.Unix(1672545600, 0)},
+	}
+
+	var buf bytes.Buffer
+	displayWho(&buf, sessions)
+
+	expected := "user1    tty1         2022-12-31 16:00\n" +
+		"user2    pts/0        2023-01-01 04:00 (192.168.1.100)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, buf.String())
+	}
+}
+
+// TestTruncate tests the truncate function.
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"server.example.com", 0, "server.example.com"},
+		{"server.example.com", 18, "server.example.com"},
+		{"server.example.com", 10, "server.ex…"},
+		{"server.example.com", 1, "…"},
+		{"höst.example.com", 4, "hös…"},
+	}
+
+	for _, test := range tests {
+		result := truncate(test.s, test.n)
+		if result != test.expected {
+			t.Errorf("truncate(%q, %d) = %q; expected %q", test.s, test.n, result, test.expected)
+		}
+	}
+}
+
+// TestParseFlagsUser tests the positional user argument.
+func TestParseFlagsUser(t *testing.T) {
+	opts, err := parseFlags([]string{"--count", "user1"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	if !opts.Count {
+		t.Errorf("Expected --count to be set")
+	}
+	if opts.User != "user1" {
+		t.Errorf("Expected user 'user1', got '%s'", opts.User)
+	}
+}
+
+// TestParseFlagsHeaderOnly tests that --header-only with --json prints the
+// --info-only object.
+func TestParseFlagsHeaderOnly(t *testing.T) {
+	opts, err := parseFlags([]string{"--header-only", "--json-pretty"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	if !opts.InfoOnly || !opts.Pretty {
+		t.Errorf("Expected --info-only, indented, got %+v", opts)
+	}
+}
+
+// TestExcludeUser tests the repeatable, comma-separated --exclude-user flag
+// and that it wins over the user argument.
+func TestExcludeUser(t *testing.T) {
+	var data []byte
+	for i, user := range []string{"alice", "backup", "monitoring", "bob"} {
+		data = append(data, mockUtmpRecord(USER_PROCESS, fmt.Sprintf("pts/%d", i), user, "", 1672502400)...)
+	}
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	utmpPaths = []string{writeTempFile(t, "utmp", data)}
+	logindSessionsDir = utmpPaths[0] + ".missing"
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+	}()
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"--exclude-user=backup,monitoring"}, []string{"alice", "bob"}},
+		{[]string{"--exclude-user", "backup", "--exclude-user", "bob"}, []string{"alice", "monitoring"}},
+		{[]string{"--exclude-user=alice", "alice"}, nil},
+	}
+
+	for _, test := range tests {
+		opts, err := parseFlags(test.args)
+		if err != nil {
+			t.Fatalf("parseFlags(%q) failed: %v", test.args, err)
+		}
+		_, sessions, _, err := gather(opts)
+		if err != nil {
+			t.Fatalf("gather failed: %v", err)
+		}
+		var users []string
+		for _, session := range sessions {
+			users = append(users, session.User)
+		}
+		if strings.Join(users, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("With %q expected users %v, got %v", test.args, test.expected, users)
+		}
+	}
+}
+
+// TestParseUtmpDeadProcess tests that a DEAD_PROCESS record ends the earlier
+// session on its line, but not a later login on the same line.
+func TestParseUtmpDeadProcess(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "ghost", "10.0.0.1", 1672502400)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/1", "alice", "10.0.0.2", 1672502400)...)
+	data = append(data, mockUtmpRecord(DEAD_PROCESS, "pts/0", "", "", 1672506000)...)
+	data = append(data, mockUtmpRecord(DEAD_PROCESS, "pts/2", "", "", 1672506000)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/2", "bob", "10.0.0.3", 1672509600)...)
+
+	sessions, err := parseUtmpReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseUtmpReader failed: %v", err)
+	}
+	var users []string
+	for _, session := range sessions {
+		users = append(users, session.User)
+	}
+	if strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("Expected users alice and bob, got %v", users)
+	}
+}
+
+// TestParseUtmpStaleDeadProcess tests that a DEAD_PROCESS record older than
+// the login before it in the file, left in a later slot by an earlier
+// session on the same line, does not end that login.
+func TestParseUtmpStaleDeadProcess(t *testing.T) {
+	record := func(typ int16, id, user string, sec, usec int32) []byte {
+		entry := utmp{Type: typ, TimeSec: sec, TimeUsec: usec}
+		copy(entry.ID[:], id)
+		copy(entry.Line[:], "pts/0")
+		copy(entry.User[:], user)
+		return encodeUtmp(entry)
+	}
+
+	tests := []struct {
+		name     string
+		dead     []byte
+		expected string
+	}{
+		{"older", record(DEAD_PROCESS, "ts/9", "", 1672502399, 999999), "alice"},
+		{"same time", record(DEAD_PROCESS, "ts/9", "", 1672502400, 500000), ""},
+		{"newer", record(DEAD_PROCESS, "ts/0", "", 1672502400, 500001), ""},
+	}
+	for _, test := range tests {
+		data := append(record(USER_PROCESS, "ts/0", "alice", 1672502400, 500000), test.dead...)
+		sessions, err := parseUtmpReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("parseUtmpReader failed: %v", err)
+		}
+		var users []string
+		for _, session := range sessions {
+			users = append(users, session.User)
+		}
+		if strings.Join(users, ",") != test.expected {
+			t.Errorf("%s: expected users %q, got %v", test.name, test.expected, users)
+		}
+	}
+}
+
+// TestParseUtmpSlotReuse tests that only the last session in each utmp slot,
+// identified by its ID and line, is reported.
+func TestParseUtmpSlotReuse(t *testing.T) {
+	record := func(id, line, user string) []byte {
+		entry := utmp{Type: USER_PROCESS, TimeSec: 1672502400}
+		copy(entry.ID[:], id)
+		copy(entry.Line[:], line)
+		copy(entry.User[:], user)
+		return encodeUtmp(entry)
+	}
+
+	var data []byte
+	data = append(data, record("ts/0", "pts/0", "stale")...)
+	data = append(data, record("tty1", "tty1", "alice")...)
+	data = append(data, record("ts/0", "pts/0", "bob")...)
+	data = append(data, record("ts/1", "pts/0", "carol")...)
+
+	sessions, err := parseUtmpReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseUtmpReader failed: %v", err)
+	}
+	var users []string
+	for _, session := range sessions {
+		users = append(users, session.User)
+	}
+	if strings.Join(users, ",") != "alice,bob,carol" {
+		t.Errorf("Expected users alice, bob and carol, got %v", users)
+	}
+}
+
+// TestParseFlagsUserBeforeFlags tests flags after the user argument, a user
+// after "--", and the --help text.
+func TestParseFlagsUserBeforeFlags(t *testing.T) {
+	opts, err := parseFlags([]string{"bob", "--since=1h", "--count"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	if opts.User != "bob" || opts.Since != time.Hour || !opts.Count {
+		t.Errorf("Expected user bob, --since=1h and --count, got %+v", opts)
+	}
+
+	opts, err = parseFlags([]string{"--count", "--", "-v"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	if opts.User != "-v" || opts.Verbose || !opts.Count {
+		t.Errorf("Expected user -v and --count, got %+v", opts)
+	}
+	stderr := captureStderr(t, func() {
+		_, err = parseFlags([]string{"--", "bob", "--count"})
+	})
+	if err == nil || !strings.Contains(stderr, "too many arguments: bob --count") {
+		t.Errorf("Expected --count after -- to be an argument, got %v", err)
+	}
+
+	stderr = captureStderr(t, func() {
+		_, err = parseFlags([]string{"--help"})
+	})
+	if err != flag.ErrHelp {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+	for _, want := range []string{"Usage: go-w [options] [user]", "-count", "-json-pretty", "go-w bob --since=1h", "Exit status"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("Expected %q in usage:\n%s", want, stderr)
+		}
+	}
+}
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = oldStderr }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// TestRunOutput tests that run writes its output to the given writer rather
+// than to stdout.
+func TestRunOutput(t *testing.T) {
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	utmpPaths = nil
+	logindSessionsDir = t.TempDir()
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		utmpFileOnly = false
+	}()
+	utmpFile := writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "host1", 1672502400))
+
+	var buf bytes.Buffer
+	if code := run([]string{"--tsv", "--utmp-file", utmpFile}, &buf); code != exitOK {
+		t.Fatalf("run = %d; expected %d", code, exitOK)
+	}
+	if !strings.HasPrefix(buf.String(), "alice\tpts/0\thost1\t16:00\t") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Expected alice's session as TSV, got %q", buf.String())
+	}
+}
+
+// TestRunOutputFile tests writing the output to a file with --output, and
+// reporting errors writing it.
+func TestRunOutputFile(t *testing.T) {
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldNoColor := color.NoColor
+	utmpPaths = nil
+	logindSessionsDir = t.TempDir()
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		color.NoColor = oldNoColor
+		utmpFileOnly = false
+	}()
+	utmpFile := writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "host1", 1672502400))
+	output := writeTempFile(t, "output", []byte(strings.Repeat("stale\n", 100)))
+
+	color.NoColor = false
+	var buf bytes.Buffer
+	if code := run([]string{"--utmp-file", utmpFile, "--output", output}, &buf); code != exitOK {
+		t.Fatalf("run = %d; expected %d", code, exitOK)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing on stdout, got %q", buf.String())
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "alice") || strings.Contains(string(data), "stale") {
+		t.Errorf("Expected the file to be replaced with alice's session, got %q", data)
+	}
+	if strings.Contains(string(data), "\x1b[") {
+		t.Errorf("Expected no colors in the output file, got %q", data)
+	}
+
+	if _, err := os.Stat("/dev/full"); err == nil {
+		if code := run([]string{"--utmp-file", utmpFile, "--output", "/dev/full"}, &buf); code != exitError {
+			t.Errorf("run with a full disk = %d; expected %d", code, exitError)
+		}
+	}
+}
+
+// TestRunNoProcfs tests that a missing procfs is reported once, with exit
+// code 3 and before anything is gathered, except by the modes that do not
+// need it.
+func TestRunNoProcfs(t *testing.T) {
+	oldProcPath, oldProcStatPath := procPath, procStatPath
+	oldUptimePath, oldLoadAvgPath := uptimePath, loadAvgPath
+	oldUtmpPaths := utmpPaths
+	defer func() {
+		procPath, procStatPath = oldProcPath, oldProcStatPath
+		uptimePath, loadAvgPath = oldUptimePath, oldLoadAvgPath
+		utmpPaths = oldUtmpPaths
+		foreignProcfs = false
+		utmpFileOnly = false
+	}()
+	empty := t.TempDir()
+	utmpFile := writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "host1", 1672502400))
+
+	var logs, buf bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if code := run([]string{"--procfs", empty}, &buf); code != exitUnsupported {
+		t.Errorf("run without procfs = %d; expected %d", code, exitUnsupported)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing on stdout, got %q", buf.String())
+	}
+	if expected := "Error: " + empty + " is not mounted; go-w requires procfs\n"; !strings.HasSuffix(logs.String(), expected) || strings.Count(logs.String(), "\n") != 1 {
+		t.Errorf("Expected the single error %q, got %q", expected, logs.String())
+	}
+
+	utmpPaths = nil
+	if code := run([]string{"--procfs", empty, "--utmp-file", utmpFile, "--dump"}, &buf); code != exitOK {
+		t.Errorf("run --dump without procfs = %d; expected %d", code, exitOK)
+	}
+	if !strings.Contains(buf.String(), `user="alice"`) {
+		t.Errorf("Expected alice's record to be dumped, got %q", buf.String())
+	}
+
+	// Nor without the local /proc, though sysinfo(2) would give the uptime
+	foreignProcfs = false
+	procPath = empty
+	buf.Reset()
+	if code := run(nil, &buf); code != exitUnsupported {
+		t.Errorf("run without /proc = %d; expected %d", code, exitUnsupported)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing on stdout without /proc, got %q", buf.String())
+	}
+}
+
+// TestParseUtmpHostAndAddr tests how FROM is chosen between the Host and
+// Addr fields.
+func TestParseUtmpHostAndAddr(t *testing.T) {
+	tests := []struct {
+		host     string
+		addr     string
+		expected string
+	}{
+		{"192.168.1.1", "", "192.168.1.1"},
+		{"", "10.0.0.5", "10.0.0.5"},
+		{"server.example.com", "10.0.0.5", "server.example.com"},
+		{"2001:0db8:0000::0001", "", "2001:db8::1"},
+		{"::ffff:192.168.1.1", "", "192.168.1.1"},
+	}
+
+	for _, test := range tests {
+		record := mockUtmpRecord(USER_PROCESS, "pts/0", "user1", test.host, 1672502400)
+		if test.addr != "" {
+			for i, word := range addrFromIP(test.addr) {
+				binary.LittleEndian.PutUint32(record[348+i*4:], uint32(word))
+			}
+		}
+
+		sessions, err := parseUtmpReader(bytes.NewReader(record))
+		if err != nil {
+			t.Fatalf("parseUtmpReader failed: %v", err)
+		}
+		if len(sessions) != 1 {
+			t.Fatalf("Expected 1 session, got %d", len(sessions))
+		}
+		if sessions[0].From != test.expected {
+			t.Errorf("Host %q, Addr %q: expected FROM %q, got %q", test.host, test.addr, test.expected, sessions[0].From)
+		}
+	}
+}
+
+// TestParseUtmpInvalidUTF8 tests that invalid UTF-8 and control characters
+// in utmp strings are made safe to print and encode as JSON.
+func TestParseUtmpInvalidUTF8(t *testing.T) {
+	data := mockUtmpRecord(USER_PROCESS, "pts/0", "alice\x1b[2J", "host\xff\xfe.example.com", 1672502400)
+	sessions, err := parseUtmpReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parseUtmpReader failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+	if sessions[0].From != "host\uFFFD.example.com" {
+		t.Errorf("Expected invalid UTF-8 to be replaced, got %q", sessions[0].From)
+	}
+	if sessions[0].User != `alice\x1b[2J` {
+		t.Errorf("Expected the escape character to be escaped, got %q", sessions[0].User)
+	}
+	if _, err := json.Marshal(sessions); err != nil {
+		t.Errorf("json.Marshal failed: %v", err)
+	}
+	if s := "plain.example.com"; printable(s) != s {
+		t.Errorf("printable(%q) = %q", s, printable(s))
+	}
+}
+
+// BenchmarkParseUtmp benchmarks parsing a utmp file with 10k records, about
+// the size of a busy wtmp.
+func BenchmarkParseUtmp(b *testing.B) {
+	record := mockUtmpRecord(USER_PROCESS, "pts/0", "user1", "host1.example.com", 1672502400)
+	data := bytes.Repeat(record, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseUtmpReader(bytes.NewReader(data)); err != nil {
+			b.Fatalf("parseUtmpReader failed: %v", err)
+		}
+	}
+}
+
+// TestLookupUsername tests falling back to the passwd file for UIDs that
+// os/user cannot resolve.
+func TestLookupUsername(t *testing.T) {
+	oldPasswdPath := passwdPath
+	passwdPath = writeTempFile(t, "passwd", []byte(
+		"root:x:0:0:root:/root:/bin/bash\n"+
+			"svc-backup:x:54321:54321:Backup:/var/backups:/usr/sbin/nologin\n"))
+	defer func() {
+		passwdPath = oldPasswdPath
+	}()
+
+	name, err := lookupUsername(54321)
+	if err != nil {
+		t.Fatalf("lookupUsername failed: %v", err)
+	}
+	if name != "svc-backup" {
+		t.Errorf("Expected username 'svc-backup', got '%s'", name)
+	}
+
+	if _, err := lookupUsername(54322); err == nil {
+		t.Errorf("Expected an error for an unknown UID")
+	}
+}
+
+// TestLoggedInSince tests the --since filter.
+func TestLoggedInSince(t *testing.T) {
+	cutoff := time.Unix(1672502400, 0)
+	tests := []struct {
+		loginTime time.Time
+		expected  bool
+	}{
+		{time.Unix(1672502460, 0), true},
+		{cutoff, true},
+		{time.Unix(1672502340, 0), false},
+		{time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		result := loggedInSince(UserSession{LoginTime: test.loginTime}, cutoff)
+		if result != test.expected {
+			t.Errorf("loggedInSince(%v) = %v; expected %v", test.loginTime, result, test.expected)
+		}
+	}
+}
+
+// TestIdleSince tests the --idle-absolute IDLE column.
+func TestIdleSince(t *testing.T) {
+	now := time.Unix(1672502400, 0)
+	if result := idleSince(UserSession{IdleDuration: 90 * time.Minute}, now); result != "14:30" {
+		t.Errorf("Expected idle since '14:30', got '%s'", result)
+	}
+	if result := idleSince(UserSession{IdleDuration: idleUnknown}, now); result != "?" {
+		t.Errorf("Expected idle since '?', got '%s'", result)
+	}
+}
+
+// TestIdleLongerThan tests the --min-idle filter.
+func TestIdleLongerThan(t *testing.T) {
+	tests := []struct {
+		idle     time.Duration
+		expected bool
+	}{
+		{3 * time.Hour, true},
+		{2 * time.Hour, false},
+		{time.Minute, false},
+		{idleUnknown, false},
+	}
+
+	for _, test := range tests {
+		result := idleLongerThan(UserSession{IdleDuration: test.idle}, 2*time.Hour)
+		if result != test.expected {
+			t.Errorf("idleLongerThan(%v) = %v; expected %v", test.idle, result, test.expected)
+		}
+	}
+}
+
+// TestAge tests computing and formatting the time since login.
+func TestAge(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	setNow(t, now)
+
+	sessions := []UserSession{
+		{TTY: "?", LoginTime: now.Add(-(2*time.Hour + 15*time.Minute + 30*time.Second))},
+		{TTY: "?"},
+	}
+	enrichSessions(sessions)
+
+	if age := sessions[0].Age; age != 2*time.Hour+15*time.Minute+30*time.Second {
+		t.Errorf("Expected an age of 2h15m30s, got %v", age)
+	}
+	if s := sessions[0].AgeString(); s != "2:15" {
+		t.Errorf("Expected age '2:15', got '%s'", s)
+	}
+	if s := sessions[1].AgeString(); s != "?" {
+		t.Errorf("Expected age '?' for an unknown login time, got '%s'", s)
+	}
+}
+
+// FuzzParseUtmp checks that arbitrary input never makes parseUtmpReader
+// panic, and that it returns either sessions or an error, never both.
+func FuzzParseUtmp(f *testing.F) {
+	record := mockUtmpRecord(USER_PROCESS, "pts/0", "user1", "host1", 1672502400)
+	f.Add(record)
+	f.Add(record[:100])
+	f.Add(append(record, record[:10]...))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sessions, err := parseUtmpReader(bytes.NewReader(data))
+		if err != nil {
+			if sessions != nil {
+				t.Errorf("Expected no sessions alongside error %v, got %d", err, len(sessions))
+			}
+			return
+		}
+		if len(data)%utmpSize != 0 {
+			t.Errorf("Expected an error for a partial record (%d bytes)", len(data))
+		}
+		if len(sessions) > len(data)/utmpSize {
+			t.Errorf("Got %d sessions from %d records", len(sessions), len(data)/utmpSize)
+		}
+	})
+}
+
+// TestParseTimeFormat tests resolving --time-format presets and layouts.
+func TestParseTimeFormat(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"w", "15:04", true},
+		{"iso", time.RFC3339, true},
+		{"kitchen", time.Kitchen, true},
+		{"Jan 2 15:04", "Jan 2 15:04", true},
+		{"", "", false},
+		{"hh:mm", "", false},
+	}
+
+	for _, test := range tests {
+		result, err := parseTimeFormat(test.value)
+		if (err == nil) != test.valid || result != test.expected {
+			t.Errorf("parseTimeFormat(%q) = %q, %v; expected %q, valid %v", test.value, result, err, test.expected, test.valid)
+		}
+	}
+
+	oldTimeFormat := timeFormat
+	timeFormat = time.Kitchen
+	defer func() {
+		timeFormat = oldTimeFormat
+	}()
+	if result := formatTime(1672502400); result != "4:00PM" {
+		t.Errorf("formatTime with kitchen layout = %q; expected %q", result, "4:00PM")
+	}
+}
+
+// TestPlausibleIdle tests rejecting idle times from bogus access times.
+func TestPlausibleIdle(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	setNow(t, now)
+
+	boot := now.Add(-time.Hour)
+	tests := []struct {
+		idle     time.Duration
+		boot     time.Time
+		expected bool
+	}{
+		{5 * time.Minute, boot, true},
+		{-time.Second, boot, true},
+		{-time.Hour, boot, false},
+		{time.Hour, boot, true},
+		{time.Hour + time.Second, boot, false},
+		{2 * time.Hour, time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		result := plausibleIdle(test.idle, test.boot)
+		if result != test.expected {
+			t.Errorf("plausibleIdle(%v, %v) = %v; expected %v", test.idle, test.boot, result, test.expected)
+		}
+	}
+}
+
+// TestCheckLoad tests the --load-threshold exit codes and message.
+func TestCheckLoad(t *testing.T) {
+	oldLoadAvgPath := loadAvgPath
+	loadAvgPath = writeTempFile(t, "loadavg", []byte("2.50 1.10 0.05 1/123 4567\n"))
+	defer func() {
+		loadAvgPath = oldLoadAvgPath
+	}()
+
+	tests := []struct {
+		opts     options
+		code     int
+		expected string
+	}{
+		{options{LoadThreshold: 3}, exitOK, ""},
+		{options{LoadThreshold: 2.5}, exitOK, ""},
+		{options{LoadThreshold: 2}, exitLoadHigh, "load average 2.50 exceeds threshold 2.00\n"},
+		{options{LoadThreshold: 2, Quiet: true}, exitLoadHigh, ""},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		code := checkLoad(&buf, test.opts)
+		if code != test.code {
+			t.Errorf("checkLoad(%+v) = %d; expected %d", test.opts, code, test.code)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("checkLoad(%+v) printed %q; expected %q", test.opts, buf.String(), test.expected)
+		}
+	}
+
+	loadAvgPath = loadAvgPath + ".missing"
+	if code := checkLoad(io.Discard, options{LoadThreshold: 1}); code != exitError {
+		t.Errorf("checkLoad with a missing loadavg = %d; expected %d", code, exitError)
+	}
+}
+
+// TestNormalizeTTY tests the terminal name shapes produced by utmp and /proc.
+func TestNormalizeTTY(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"pts/0", "pts/0"},
+		{"/dev/pts/0", "pts/0"},
+		{"0", "pts/0"},
+		{"12", "pts/12"},
+		{"tty1", "tty1"},
+		{"/dev/tty1", "tty1"},
+		{"/dev/console", "console"},
+		{":0", ":0"},
+		{"?", "?"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		result := normalizeTTY(test.raw)
+		if result != test.expected {
+			t.Errorf("normalizeTTY(%q) = %q; expected %q", test.raw, result, test.expected)
+		}
+	}
+}
+
+// TestGroupSessions tests grouping sessions by host in first-seen order.
+func TestGroupSessions(t *testing.T) {
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0", From: "10.0.0.1"},
+		{User: "bob", TTY: "tty1", From: ""},
+		{User: "carol", TTY: "pts/1", From: "10.0.0.1"},
+	}
+
+	groups := groupSessions(sessions, func(s UserSession) string { return s.From })
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].Key != "10.0.0.1" || len(groups[0].Sessions) != 2 {
+		t.Errorf("Expected 2 sessions from 10.0.0.1 first, got %+v", groups[0])
+	}
+	if groups[0].Sessions[1].User != "carol" {
+		t.Errorf("Expected carol second in its group, got %s", groups[0].Sessions[1].User)
+	}
+	if groups[1].Key != "" || len(groups[1].Sessions) != 1 {
+		t.Errorf("Expected 1 local session second, got %+v", groups[1])
+	}
+
+	oldNoColor := color.NoColor
+	color.NoColor = true
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	var buf bytes.Buffer
+	displayGroups(&buf, groups, options{})
+	for _, heading := range []string{"10.0.0.1 (2 sessions)\n", "- (1 session)\n"} {
+		if !strings.Contains(buf.String(), heading) {
+			t.Errorf("Expected heading %q in output:\n%s", heading, buf.String())
+		}
+	}
+}
+
+// TestUtmpRoundTrip tests that records encoded with encodeUtmp decode to the
+// same record and parse to sessions matching their fields.
+func TestUtmpRoundTrip(t *testing.T) {
+	oldSessionTypes := sessionTypes
+	sessionTypes = map[int16]bool{USER_PROCESS: true, LOGIN_PROCESS: true}
+	defer func() {
+		sessionTypes = oldSessionTypes
+	}()
+
+	record := func(typ int16, line, user, host string, sec, usec int32) utmp {
+		entry := utmp{Type: typ, Pid: 4321, Session: 7, TimeSec: sec, TimeUsec: usec}
+		copy(entry.Line[:], line)
+		copy(entry.ID[:], "ts/0")
+		copy(entry.User[:], user)
+		copy(entry.Host[:], host)
+		return entry
+	}
+	withAddr := func(entry utmp, addr [4]int32) utmp {
+		entry.Addr = addr
+		return entry
+	}
+
+	longUser := strings.Repeat("u", 32)
+	longHost := strings.Repeat("h", 256)
+	tests := []struct {
+		entry    utmp
+		expected UserSession
+	}{
+		{
+			record(USER_PROCESS, "pts/0", "alice", "example.com", 1672502400, 123456),
+			UserSession{User: "alice", TTY: "pts/0", From: "example.com", What: "-", LoginTime: time.Unix(1672502400, 123456000)},
+		},
+		{
+			withAddr(record(USER_PROCESS, "pts/1", "bob", "", 1672545600, 0), addrFromIP("192.168.1.100")),
+			UserSession{User: "bob", TTY: "pts/1", From: "192.168.1.100", What: "-", LoginTime: time.Unix(1672545600, 0)},
+		},
+		{
+			record(LOGIN_PROCESS, "tty1", "LOGIN", "", 0, 0),
+			UserSession{User: "LOGIN", TTY: "tty1", What: "LOGIN_PROCESS", LoginTime: time.Unix(0, 0)},
+		},
+		{
+			record(USER_PROCESS, "tty2", "jöse", "bücher.example", math.MaxInt32, 999999),
+			UserSession{User: "jöse", TTY: "tty2", From: "bücher.example", What: "-", LoginTime: time.Unix(math.MaxInt32, 999999000)},
+		},
+		{
+			// Fields filling their whole width have no terminating NUL
+			record(USER_PROCESS, "pts/2", longUser, longHost, -1, 0),
+			UserSession{User: longUser, TTY: "pts/2", From: longHost, What: "-", LoginTime: time.Unix(-1, 0)},
+		},
+	}
+
+	for _, test := range tests {
+		data := encodeUtmp(test.entry)
+
+		var decoded utmp
+		decodeUtmp(data, &decoded)
+		if decoded != test.entry {
+			t.Errorf("decodeUtmp(encodeUtmp(%+v)) = %+v", test.entry, decoded)
+		}
+
+		sessions, err := parseUtmpReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("parseUtmpReader failed: %v", err)
+		}
+		if len(sessions) != 1 {
+			t.Fatalf("Expected 1 session, got %d", len(sessions))
+		}
+		got := sessions[0]
+		if got.User != test.expected.User || got.TTY != test.expected.TTY || got.From != test.expected.From ||
+			got.What != test.expected.What || !got.LoginTime.Equal(test.expected.LoginTime) {
+			t.Errorf("Expected session %+v, got %+v", test.expected, got)
+		}
+		if got.Session != int(test.entry.Session) {
+			t.Errorf("Expected session ID %d, got %d", test.entry.Session, got.Session)
+		}
+	}
+}
+
+// ansiPattern matches the SGR color escape sequences written by fatih/color.
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// TestDisplaySessionsAlignment tests that colored output lines up with the
+// column headings once the escape codes are stripped, and matches the
+// uncolored output exactly.
+func TestDisplaySessionsAlignment(t *testing.T) {
+	oldNoColor := color.NoColor
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0", From: "10.0.0.1", LoginTime: time.Unix(1672502400, 0), Idle: "1.00s", IdleDuration: time.Second, JCPU: "0.00s", PCPU: "0.00s", What: "-"},
+		{User: "bob", TTY: "tty1", From: "", LoginTime: time.Unix(1672545600, 0), Idle: "3:04m", IdleDuration: 3 * time.Hour, JCPU: "0.00s", PCPU: "0.00s", What: "vim"},
+		{User: "jöse", TTY: "pts/12", From: "bücher.example", Idle: "?", IdleDuration: idleUnknown, JCPU: "0.00s", PCPU: "0.00s", What: "-"},
+	}
+	opts := options{Theme: themes["dark"], IdleWarn: time.Minute, IdleCrit: time.Hour}
+
+	render := func(noColor bool) []string {
+		color.NoColor = noColor
+		var buf bytes.Buffer
+		displayHeader(&buf, SystemInfo{}, "test", opts)
+		displaySessions(&buf, sessions, opts)
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+	colored := render(false)
+	plain := render(true)
+
+	if !strings.Contains(colored[2], "\x1b[") {
+		t.Fatalf("Expected colored output, got %q", colored[2])
+	}
+	for i := range colored {
+		if stripped := ansiPattern.ReplaceAllString(colored[i], ""); stripped != plain[i] {
+			t.Errorf("Line %d: colored output %q does not match uncolored %q", i, stripped, plain[i])
+		}
+	}
+
+	// Every column but WHAT, which follows the right-aligned PCPU heading,
+	// starts under its heading
+	headings := []string{"USER", "TTY", "FROM", "LOGIN@", "IDLE", "JCPU", "PCPU"}
+	for i, session := range sessions {
+		line := []rune(plain[i+2])
+		values := []string{session.User, session.TTY, session.From, session.LoginAt(), session.Idle, session.JCPU, session.PCPU}
+		for j, heading := range headings {
+			start := utf8.RuneCountInString(plain[1][:strings.Index(plain[1], heading)])
+			if !strings.HasPrefix(string(line[start:]), values[j]) {
+				t.Errorf("Expected %s %q at column %d in %q", heading, values[j], start, plain[i+2])
+			}
+		}
+	}
+}
+
+// TestRender tests the header and session rows of the default output, with
+// and without the optional columns.
+func TestRender(t *testing.T) {
+	oldNoColor := color.NoColor
+	color.NoColor = true
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	info := SystemInfo{CurrentTime: "14:30:45", Uptime: "1:23", LoadAvg: "0.15 0.10 0.05", Users: 2}
+	sessions := []UserSession{
+		{User: "john", TTY: "tty1", From: ":0", LoginTime: time.Unix(1672502400, 0), Age: 90 * time.Minute, Idle: "3.00s", IdleDuration: 3 * time.Second, JCPU: "0.00s", PCPU: "0.00s", What: "-", Seat: "seat0", Session: 1234, Procs: 3},
+		{User: "jane", TTY: "pts/0", From: "192.168.1.100", LoginTime: time.Unix(1672503300, 0), Age: 75 * time.Minute, Idle: "5:02", IdleDuration: 5 * time.Minute, JCPU: "0.00s", PCPU: "0.00s", What: "vim"},
+	}
+
+	tests := []struct {
+		opts     options
+		expected []string
+	}{
+		{options{}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER     TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT",
+			"john     tty1     :0               16:00    3.00s  0.00s  0.00s  -",
+			"jane     pts/0    192.168.1.100    16:15    5:02   0.00s  0.00s  vim",
+		}},
+		{options{Seat: true, Age: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER     TTY      SEAT     FROM             LOGIN@   AGE      IDLE   JCPU   PCPU WHAT",
+			"john     tty1     seat0    :0               16:00    1:30     3.00s  0.00s  0.00s  -",
+			"jane     pts/0             192.168.1.100    16:15    1:15     5:02   0.00s  0.00s  vim",
+		}},
+		{options{SID: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER     TTY      SID      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT",
+			"john     tty1     1234     :0               16:00    3.00s  0.00s  0.00s  -",
+			"jane     pts/0    ?        192.168.1.100    16:15    5:02   0.00s  0.00s  vim",
+		}},
+		{options{NProc: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER     TTY      NPROC FROM             LOGIN@   IDLE   JCPU   PCPU WHAT",
+			"john     tty1     3     :0               16:00    3.00s  0.00s  0.00s  -",
+			"jane     pts/0    0     192.168.1.100    16:15    5:02   0.00s  0.00s  vim",
+		}},
+		{options{Compact: true, Seat: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER TTY SEAT FROM LOGIN@ IDLE JCPU PCPU WHAT",
+			"john tty1 seat0 :0 16:00 3.00s 0.00s 0.00s -",
+			"jane pts/0 - 192.168.1.100 16:15 5:02 0.00s 0.00s vim",
+		}},
+		{options{StatusIcons: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"  USER     TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT",
+			"* john     tty1     :0               16:00    3.00s  0.00s  0.00s  -",
+			". jane     pts/0    192.168.1.100    16:15    5:02   0.00s  0.00s  vim",
+		}},
+		{options{UserWidth: 6}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+			"USER   TTY      FROM             LOGIN@   IDLE   JCPU   PCPU WHAT",
+			"john   tty1     :0               16:00    3.00s  0.00s  0.00s  -",
+			"jane   pts/0    192.168.1.100    16:15    5:02   0.00s  0.00s  vim",
+		}},
+		{options{HeaderOnly: true}, []string{
+			" 14:30:45 up 1:23,  2 users,  load average: 0.15 0.10 0.05 (using /run/utmp)",
+		}},
+	}
+
+	for _, test := range tests {
+		test.opts.Theme = themes["dark"]
+		test.opts.IdleWarn, test.opts.IdleCrit = time.Minute, time.Hour
+		var buf bytes.Buffer
+		if err := render(&buf, test.opts, info, sessions, "using /run/utmp"); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(test.expected) {
+			t.Fatalf("Expected %d lines, got %d:\n%s", len(test.expected), len(lines), buf.String())
+		}
+		for i, line := range lines {
+			if line != test.expected[i] {
+				t.Errorf("Line %d:\nexpected %q\n     got %q", i, test.expected[i], line)
+			}
+		}
+	}
+}
+
+// TestUserWidth tests truncating long user names to --user-width.
+func TestUserWidth(t *testing.T) {
+	oldNoColor := color.NoColor
+	color.NoColor = true
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	sessions := []UserSession{{User: "firstname.lastname", TTY: "pts/0", From: "10.0.0.1", Idle: "1.00s", JCPU: "0.00s", PCPU: "0.00s", What: "-"}}
+	var buf bytes.Buffer
+	displaySessions(&buf, sessions, options{UserWidth: 10, Theme: themes["none"]})
+	if !strings.HasPrefix(buf.String(), "firstname… pts/0    10.0.0.1") {
+		t.Errorf("Expected the name truncated to 10 characters, got %q", buf.String())
+	}
+}
+
+// TestDisplayJSON tests the compact and indented JSON output.
+func TestDisplayJSON(t *testing.T) {
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0", From: "10.0.0.1", Idle: "5.00s", JCPU: "0.00s", PCPU: "0.00s", What: "-", Session: 4321, LoginTime: time.Unix(1672502400, 0).UTC(), IdleDuration: 5 * time.Second},
+	}
+
+	var compact, pretty bytes.Buffer
+	if err := displayJSON(&compact, sessions, false); err != nil {
+		t.Fatalf("displayJSON failed: %v", err)
+	}
+	if err := displayJSON(&pretty, sessions, true); err != nil {
+		t.Fatalf("displayJSON failed: %v", err)
+	}
+
+	expected := `[{"user":"alice","tty":"pts/0","from":"10.0.0.1","idle":"5.00s","jcpu":"0.00s","pcpu":"0.00s","what":"-","session":4321,"login_time":"2022-12-31T16:00:00Z","idle_duration":5000000000,"age":0}]` + "\n"
+	if compact.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, compact.String())
+	}
+	if !strings.HasPrefix(pretty.String(), "[\n  {\n    \"user\": \"alice\",\n") {
+		t.Errorf("Expected two-space indentation, got:\n%s", pretty.String())
+	}
+
+	// Both forms have the same schema
+	var fromCompact, fromPretty []UserSession
+	if err := json.Unmarshal(compact.Bytes(), &fromCompact); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(pretty.Bytes(), &fromPretty); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromCompact, fromPretty) {
+		t.Errorf("Compact and indented JSON differ: %+v and %+v", fromCompact, fromPretty)
+	}
+
+	var empty bytes.Buffer
+	if err := displayJSON(&empty, nil, false); err != nil || empty.String() != "[]\n" {
+		t.Errorf("Expected an empty array for no sessions, got %q (%v)", empty.String(), err)
+	}
+}
+
+// TestDisplayInfoJSON tests printing only the system information, with
+// unreadable values as null.
+func TestDisplayInfoJSON(t *testing.T) {
+	setNow(t, time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC))
+	info, err := newSystemInfo(90*time.Minute+500*time.Millisecond, nil, loadAverage{[3]float64{0.15, 0.1, 0.05}, "0.15 0.10 0.05"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Users = 2
+
+	var buf bytes.Buffer
+	if err := displayInfoJSON(&buf, info, false); err != nil {
+		t.Fatalf("displayInfoJSON() error = %v", err)
+	}
+	expected := `{"time":"2023-01-01T12:00:00Z","uptime_seconds":5400.5,"load1":0.15,"load5":0.1,"load15":0.05,"users":2}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+
+	info, _ = newSystemInfo(0, errors.New("no uptime"), loadAverage{}, errors.New("no load"))
+	buf.Reset()
+	if err := displayInfoJSON(&buf, info, true); err != nil {
+		t.Fatalf("displayInfoJSON() error = %v", err)
+	}
+	expected = `{
+  "time": "2023-01-01T12:00:00Z",
+  "uptime_seconds": null,
+  "load1": null,
+  "load5": null,
+  "load15": null,
+  "users": 0
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+}
+
+// TestRenderEmpty tests that only the human-readable views explain an empty
+// session list.
+func TestRenderEmpty(t *testing.T) {
+	tests := []struct {
+		opts    options
+		message bool
+	}{
+		{options{}, true},
+		{options{Table: true}, true},
+		{options{GroupBy: "host"}, true},
+		{options{TSV: true}, false},
+		{options{Who: true}, false},
+		{options{Count: true}, false},
+		{options{JSON: true}, false},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := render(&buf, test.opts, SystemInfo{}, nil, "test"); err != nil {
+			t.Fatalf("render(%+v) failed: %v", test.opts, err)
+		}
+		if got := strings.Contains(buf.String(), "no users logged in"); got != test.message {
+			t.Errorf("render(%+v) printed the empty-state message: %v; expected %v", test.opts, got, test.message)
+		}
+	}
+}
+
+// TestSampleLoad tests sampling the load average and summarizing the
+// samples, which cannot be taken of a remote host.
+func TestSampleLoad(t *testing.T) {
+	oldLoadAvgPath := loadAvgPath
+	loadAvgPath = writeTempFile(t, "loadavg", []byte("0.50 0.40 0.30 1/123 4567\n"))
+	defer func() {
+		loadAvgPath = oldLoadAvgPath
+	}()
+
+	samples, err := sampleLoad(3, 0)
+	if err != nil {
+		t.Fatalf("sampleLoad failed: %v", err)
+	}
+	if len(samples) != 3 || samples[0] != 0.5 {
+		t.Errorf("Expected 3 samples of 0.5, got %v", samples)
+	}
+
+	low, high, avg := loadStats([]float64{0.2, 0.8, 0.5})
+	if low != 0.2 || high != 0.8 || avg != 0.5 {
+		t.Errorf("loadStats = %v, %v, %v; expected 0.2, 0.8, 0.5", low, high, avg)
+	}
+
+	var buf bytes.Buffer
+	displaySummary(&buf, SystemInfo{LoadSamples: []float64{0.2, 0.8, 0.5}}, "test", Theme{})
+	if !strings.Contains(buf.String(), " load trend: min 0.20, max 0.80, avg 0.50 (3 samples)\n") {
+		t.Errorf("Expected a load trend line, got:\n%s", buf.String())
+	}
+
+	stderr := captureStderr(t, func() {
+		_, err = parseFlags([]string{"--sample=3", "--host=example.com"})
+	})
+	if err == nil || !strings.Contains(stderr, "--sample cannot be combined with --host") {
+		t.Errorf("Expected --sample with --host to be rejected, got %v", err)
+	}
+}
+
+// TestCPUIdle tests reading the idle time from /proc/uptime and deriving
+// the average CPU utilization.
+func TestCPUIdle(t *testing.T) {
+	oldUptimePath := uptimePath
+	oldProcStatPath := procStatPath
+	uptimePath = writeTempFile(t, "uptime", []byte("1000.00 3000.00\n"))
+	procStatPath = writeTempFile(t, "stat", []byte("cpu  1 2 3 4\ncpu0 1 2 3 4\ncpu1 1 2 3 4\ncpu2 1 2 3 4\ncpu3 1 2 3 4\nintr 5\nbtime 1672502400\n"))
+	defer func() {
+		uptimePath = oldUptimePath
+		procStatPath = oldProcStatPath
+	}()
+
+	uptime, idle, err := readUptimeFull()
+	if err != nil {
+		t.Fatalf("readUptimeFull failed: %v", err)
+	}
+	if uptime != 1000*time.Second || idle != 3000*time.Second {
+		t.Errorf("Expected uptime 1000s and idle 3000s, got %v and %v", uptime, idle)
+	}
+	if n := onlineCPUs(); n != 4 {
+		t.Errorf("Expected 4 CPUs, got %d", n)
+	}
+
+	tests := []struct {
+		uptime, idle time.Duration
+		ncpu         int
+		expected     float64
+	}{
+		{1000 * time.Second, 3000 * time.Second, 4, 0.25},
+		{1000 * time.Second, 0, 1, 1},
+		{1000 * time.Second, 1000 * time.Second, 1, 0},
+		{1000 * time.Second, 5000 * time.Second, 4, 0}, // Idle time counted ahead of uptime
+		{0, 0, 4, 0},
+	}
+	for _, test := range tests {
+		if result := cpuUtilization(test.uptime, test.idle, test.ncpu); math.Abs(result-test.expected) > 1e-9 {
+			t.Errorf("cpuUtilization(%v, %v, %d) = %v; expected %v", test.uptime, test.idle, test.ncpu, result, test.expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	displaySummary(&buf, SystemInfo{CurrentTime: "12:00:00", Uptime: "0:16:40", LoadAvg: "0.00 0.00 0.00", CPUIdle: idle, CPUs: 4, CPUUtil: 0.25}, "test", Theme{})
+	if !strings.Contains(buf.String(), " cpu idle: 50:00 across 4 CPUs, 25.0% average utilization since boot\n") {
+		t.Errorf("Unexpected summary:\n%s", buf.String())
+	}
+}
+
+// TestLongUptime tests uptimes measured in years and out-of-range values.
+func TestLongUptime(t *testing.T) {
+	tenYears := fmt.Sprintf("%d.25 1000.00\n", 3652*24*60*60+3*60*60+4*60+5)
+	uptime, err := parseUptime(tenYears)
+	if err != nil {
+		t.Fatalf("parseUptime(%q) failed: %v", tenYears, err)
+	}
+	if result := formatUptime(uptime); result != "3652 days, 3:04:05" {
+		t.Errorf("Expected uptime '3652 days, 3:04:05', got '%s'", result)
+	}
+
+	tests := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{5 * time.Minute, "5:00"},
+		{25 * time.Hour, "1 day, 1:00:00"},
+		{400 * 24 * time.Hour, "400 days, 0:00"},
+	}
+	for _, test := range tests {
+		if result := formatUptime(test.duration); result != test.expected {
+			t.Errorf("formatUptime(%v) = %q; expected %q", test.duration, result, test.expected)
+		}
+	}
+
+	for _, data := range []string{"1e300 0\n", "-5 0\n", "NaN 0\n"} {
+		if _, err := parseUptime(data); err == nil {
+			t.Errorf("parseUptime(%q) succeeded; expected an error", data)
+		}
+	}
+}
+
+// TestPlural tests the counts in the header and group headings for zero,
+// one and many of each unit.
+func TestPlural(t *testing.T) {
+	tests := []struct {
+		n                   int
+		singular, pluralize string
+		expected            string
+	}{
+		{0, "user", "users", "0 users"},
+		{1, "user", "users", "1 user"},
+		{12, "user", "users", "12 users"},
+		{0, "session", "sessions", "0 sessions"},
+		{1, "session", "sessions", "1 session"},
+		{3, "session", "sessions", "3 sessions"},
+		{0, "CPU", "CPUs", "0 CPUs"},
+		{1, "CPU", "CPUs", "1 CPU"},
+		{64, "CPU", "CPUs", "64 CPUs"},
+	}
+	for _, test := range tests {
+		if result := plural(test.n, test.singular, test.pluralize); result != test.expected {
+			t.Errorf("plural(%d, %q, %q) = %q; expected %q", test.n, test.singular, test.pluralize, result, test.expected)
+		}
+	}
+
+	days := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{3*time.Hour + 4*time.Minute + 5*time.Second, "3:04:05"},
+		{24*time.Hour + 5*time.Second, "1 day, 0:05"},
+		{400*24*time.Hour + time.Hour, "400 days, 1:00:00"},
+	}
+	for _, test := range days {
+		if result := formatUptime(test.duration); result != test.expected {
+			t.Errorf("formatUptime(%v) = %q; expected %q", test.duration, result, test.expected)
+		}
+	}
+
+	for users, expected := range []string{"0 users", "1 user", "2 users"} {
+		var buf bytes.Buffer
+		displaySummary(&buf, SystemInfo{CurrentTime: "12:00:00", Uptime: "1:00:00", LoadAvg: "0.00 0.00 0.00", Users: users}, "test", Theme{})
+		if !strings.Contains(buf.String(), "up 1:00:00,  "+expected+",  load average") {
+			t.Errorf("Expected %q in the header, got %q", expected, buf.String())
+		}
+	}
+}
+
+// TestParseUtmpFileRetry tests that a short read is retried and a missing
+// file is not.
+func TestParseUtmpFileRetry(t *testing.T) {
+	record := mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672502400)
+
+	oldOpenUtmp := openUtmp
+	oldUtmpRetryDelay := utmpRetryDelay
+	utmpRetryDelay = 0
+	defer func() {
+		openUtmp = oldOpenUtmp
+		utmpRetryDelay = oldUtmpRetryDelay
+	}()
+
+	// The first read catches the record half written
+	opens := 0
+	openUtmp = func(string) (io.ReadCloser, error) {
+		opens++
+		if opens == 1 {
+			return io.NopCloser(bytes.NewReader(record[:100])), nil
+		}
+		return io.NopCloser(bytes.NewReader(record)), nil
+	}
+	sessions, err := parseUtmpFile("utmp")
+	if err != nil {
+		t.Fatalf("parseUtmpFile failed: %v", err)
+	}
+	if opens != 2 || len(sessions) != 1 || sessions[0].User != "alice" {
+		t.Errorf("Expected alice's session after 2 opens, got %d sessions after %d opens", len(sessions), opens)
+	}
+
+	opens = 0
+	openUtmp = func(string) (io.ReadCloser, error) {
+		opens++
+		return nil, fs.ErrNotExist
+	}
+	if _, err := parseUtmpFile("utmp"); err == nil {
+		t.Errorf("parseUtmpFile of a missing file succeeded; expected an error")
+	}
+	if opens != 1 {
+		t.Errorf("Expected a missing file to be opened once, got %d opens", opens)
+	}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logindSessionsDir is where systemd-logind keeps its per-session state files.
+var logindSessionsDir = "/run/systemd/sessions"
+
+// logindSource reads sessions from the systemd-logind state directory.
+type logindSource struct {
+	dir string
+}
+
+// Name returns the logind state directory.
+func (s logindSource) Name() string {
+	return s.dir
+}
+
+// Sessions reads every session state file in the logind directory. It
+// returns an error if systemd is not running or the directory is missing, so
+// callers can fall back to utmp on systems without logind.
+func (s logindSource) Sessions() ([]UserSession, error) {
+	// Like sd_booted(3), treat the "system" directory next to the sessions
+	// directory as the sign that systemd is running; the sessions directory
+	// itself can be left behind on systems that merely have it installed.
+	if _, err := os.Stat(filepath.Join(s.dir, "..", "system")); err != nil {
+		return nil, fmt.Errorf("systemd is not running: %w", err)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logind sessions: %w", err)
+	}
+
+	var sessions []UserSession
+	for _, entry := range entries {
+		// Skip the "<id>.ref" FIFOs that sit next to the state files
+		if !entry.Type().IsRegular() || strings.Contains(entry.Name(), ".") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			logger.Debug("skipping logind session", "id", entry.Name(), "err", err)
+			continue
+		}
+
+		session, ok := parseLogindSession(parseEnvFile(string(data)))
+		if ok {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+// parseLogindSession builds a UserSession from the fields of a logind
+// session state file. It reports false for sessions that do not belong to a
+// logged-in user, such as display manager greeters, and for sessions with
+// neither a terminal nor a display, such as ssh commands and scp, which w
+// does not list.
+func parseLogindSession(fields map[string]string) (UserSession, bool) {
+	if fields["CLASS"] != "user" || fields["STATE"] == "closing" {
+		return UserSession{}, false
+	}
+
+	tty := fields["TTY"]
+	if tty == "" {
+		tty = fields["DISPLAY"]
+	}
+	if tty == "" {
+		return UserSession{}, false
+	}
+
+	from := fields["REMOTE_HOST"]
+	if from == "" {
+		from = fields["DISPLAY"]
+	}
+
+	// The session leader's PID is the kernel session ID
+	leader, _ := strconv.Atoi(fields["LEADER"])
+
+	var loginTime time.Time
+	if usec, err := strconv.ParseInt(fields["REALTIME"], 10, 64); err == nil {
+		loginTime = time.UnixMicro(usec)
+	}
+
+	return UserSession{
+		User: fields["USER"],
+		TTY:  tty,
+		From: from,
+		Idle: ".",
+		JCPU: "0.00s",
+		PCPU: "0.00s",
+		What: "-",
+		Seat: fields["SEAT"],
+
+		Session:   leader,
+		LoginTime: loginTime,
+	}, true
+}
+
+// parseEnvFile parses KEY=VALUE lines, ignoring blank lines and comments.
+func parseEnvFile(data string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
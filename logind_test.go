@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLogindSource tests reading sessions from a mock logind state directory.
+func TestLogindSource(t *testing.T) {
+	runDir := t.TempDir()
+	sessionsDir := filepath.Join(runDir, "sessions")
+	if err := os.Mkdir(sessionsDir, 0755); err != nil {
+		t.Fatalf("Failed to create sessions directory: %v", err)
+	}
+
+	// Without the systemd marker directory the source must fail so that
+	// parseUtmp falls back to utmp
+	source := logindSource{dir: sessionsDir}
+	if _, err := source.Sessions(); err == nil {
+		t.Errorf("Expected an error when systemd is not running")
+	}
+
+	if err := os.Mkdir(filepath.Join(runDir, "system"), 0755); err != nil {
+		t.Fatalf("Failed to create system directory: %v", err)
+	}
+
+	files := map[string]string{
+		"2":  "# This is private data. Do not parse.\nUID=1000\nUSER=alice\nSTATE=active\nCLASS=user\nSEAT=seat0\nTTY=tty2\nDISPLAY=:0\nREALTIME=1672502400000000\n",
+		"5":  "UID=1001\nUSER=bob\nSTATE=active\nCLASS=user\nTTY=pts/0\nREMOTE_HOST=192.168.1.100\nLEADER=4321\nREALTIME=1672545600000000\n",
+		"c1": "UID=120\nUSER=gdm\nSTATE=online\nCLASS=greeter\nSEAT=seat0\n",
+		"7":  "UID=1001\nUSER=bob\nSTATE=active\nCLASS=user\nREMOTE_HOST=192.168.1.100\nSERVICE=sshd\nREALTIME=1672545700000000\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sessionsDir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write session file: %v", err)
+		}
+	}
+
+	sessions, err := source.Sessions()
+	if err != nil {
+		t.Fatalf("Sessions failed: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	expected := []UserSession{
+		{User: "alice", TTY: "tty2", From: ":0", Idle: ".", JCPU: "0.00s", PCPU: "0.00s", What: "-", Seat: "seat0", LoginTime: time.Unix(1672502400, 0)},
+		{User: "bob", TTY: "pts/0", From: "192.168.1.100", Idle: ".", JCPU: "0.00s", PCPU: "0.00s", What: "-", Session: 4321, LoginTime: time.Unix(1672545600, 0)},
+	}
+	for i := range expected {
+		if sessions[i] != expected[i] {
+			t.Errorf("Expected session %+v, got %+v", expected[i], sessions[i])
+		}
+	}
+
+	for i, loginAt := range []string{"16:00", "04:00"} {
+		if sessions[i].LoginAt() != loginAt {
+			t.Errorf("Expected login time '%s', got '%s'", loginAt, sessions[i].LoginAt())
+		}
+	}
+}
+
+// mockLogind creates a logind state directory for a running systemd with a
+// session of user on tty, and returns its sessions directory.
+func mockLogind(t *testing.T, user, tty string) string {
+	t.Helper()
+	runDir := t.TempDir()
+	sessionsDir := filepath.Join(runDir, "sessions")
+	for _, dir := range []string{sessionsDir, filepath.Join(runDir, "system")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	data := "UID=1000\nUSER=" + user + "\nSTATE=active\nCLASS=user\nTTY=" + tty + "\nREALTIME=1672502400000000\n"
+	if err := os.WriteFile(filepath.Join(sessionsDir, "1"), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write session file: %v", err)
+	}
+	return sessionsDir
+}
+
+// TestRunUtmpFileOverLogind tests that --utmp-file is the only session source,
+// even with systemd running.
+func TestRunUtmpFileOverLogind(t *testing.T) {
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldSessionTypes := sessionTypes
+	logindSessionsDir = mockLogind(t, "logind-user", "pts/9")
+	sessionTypes = map[int16]bool{USER_PROCESS: true}
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		sessionTypes = oldSessionTypes
+		utmpFileOnly = false
+	}()
+	utmpFile := writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "host1", 1672502400))
+
+	var buf bytes.Buffer
+	if code := run([]string{"--tsv", "--all", "--utmp-file", utmpFile}, &buf); code != exitOK {
+		t.Fatalf("run = %d; expected %d", code, exitOK)
+	}
+	if !strings.HasPrefix(buf.String(), "alice\tpts/0\t") || strings.Contains(buf.String(), "logind-user") {
+		t.Errorf("Expected only alice's session from the utmp file, got %q", buf.String())
+	}
+
+	missing := utmpFile + ".missing"
+	buf.Reset()
+	if code := run([]string{"--tsv", "--utmp-file", missing}, &buf); code == exitOK {
+		t.Errorf("run with a missing utmp file = %d; expected an error", code)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no sessions from other sources, got %q", buf.String())
+	}
+}
+
+// TestRunAllOverLogind tests that --all reads the sessions from utmp, with
+// its login processes, even with systemd running.
+func TestRunAllOverLogind(t *testing.T) {
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldSessionTypes := sessionTypes
+	data := mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "host1", 1672502400)
+	data = append(data, mockUtmpRecord(LOGIN_PROCESS, "tty1", "LOGIN", "", 1672502400)...)
+	utmpPaths = []string{writeTempFile(t, "utmp", data)}
+	logindSessionsDir = mockLogind(t, "logind-user", "pts/9")
+	sessionTypes = map[int16]bool{USER_PROCESS: true}
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		sessionTypes = oldSessionTypes
+	}()
+
+	var buf bytes.Buffer
+	if code := run([]string{"--tsv"}, &buf); code != exitOK {
+		t.Fatalf("run = %d; expected %d", code, exitOK)
+	}
+	if !strings.HasPrefix(buf.String(), "logind-user\tpts/9\t") || strings.Contains(buf.String(), "alice") {
+		t.Errorf("Expected the logind session without --all, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if code := run([]string{"--tsv", "--all"}, &buf); code != exitOK {
+		t.Fatalf("run --all = %d; expected %d", code, exitOK)
+	}
+	if !strings.Contains(buf.String(), "alice\tpts/0\t") || !strings.Contains(buf.String(), "LOGIN\ttty1\t") || strings.Contains(buf.String(), "logind-user") {
+		t.Errorf("Expected the utmp sessions with --all, got %q", buf.String())
+	}
+}
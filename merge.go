@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// mergeTTYs condenses the sessions of each user into one row for
+// --merge-ttys, in the order each user first appears. The row lists all of
+// the user's terminals, comma-separated, and takes:
+//
+//   - the login time and age of the earliest login;
+//   - the idle time, origin, seat, PCPU and WHAT of the least idle session,
+//     the one the user is most likely at;
+//   - the sum of the sessions' JCPU, since each terminal's jobs are distinct.
+func mergeTTYs(sessions []UserSession) []UserSession {
+	groups := groupSessions(sessions, func(s UserSession) string { return s.User })
+	merged := make([]UserSession, 0, len(groups))
+	for _, group := range groups {
+		merged = append(merged, mergeSessions(group.Sessions))
+	}
+	return merged
+}
+
+// mergeSessions combines the sessions of one user as described for
+// mergeTTYs.
+func mergeSessions(sessions []UserSession) UserSession {
+	active := sessions[0]
+	earliest := sessions[0]
+	ttys := make([]string, 0, len(sessions))
+	var jcpu float64
+	jcpuKnown := true
+	for _, session := range sessions {
+		ttys = append(ttys, session.TTY)
+		if lessIdle(session, active) {
+			active = session
+		}
+		if !session.LoginTime.IsZero() && (earliest.LoginTime.IsZero() || session.LoginTime.Before(earliest.LoginTime)) {
+			earliest = session
+		}
+		if seconds, ok := parseCPUTime(session.JCPU); ok {
+			jcpu += seconds
+		} else {
+			jcpuKnown = false
+		}
+	}
+
+	merged := active
+	merged.TTY = strings.Join(ttys, ",")
+	merged.LoginTime = earliest.LoginTime
+	merged.Age = earliest.Age
+	if jcpuKnown {
+		merged.JCPU = fmt.Sprintf("%.2fs", jcpu)
+	} else {
+		merged.JCPU = "?"
+	}
+	return merged
+}
+
+// lessIdle reports whether a has been idle for less time than b. Unknown
+// idle times sort after known ones.
+func lessIdle(a, b UserSession) bool {
+	if b.IdleDuration == idleUnknown {
+		return a.IdleDuration != idleUnknown
+	}
+	return a.IdleDuration != idleUnknown && a.IdleDuration < b.IdleDuration
+}
+
+// parseCPUTime parses a JCPU or PCPU value in seconds, such as "0.00s".
+func parseCPUTime(s string) (float64, bool) {
+	seconds, err := strconv.ParseFloat(strings.TrimSuffix(s, "s"), 64)
+	return seconds, err == nil && strings.HasSuffix(s, "s")
+}
+
+// fillFromProc completes sessions from the processes found in /proc on the
+// same terminals, for the sessions that need it: those missing a field that
+// /proc can supply, and those utmp records as nobody, as a privilege-dropping
+// daemon's record may be, whose user is then taken from the terminal's first
+// process, the one with the lowest PID, which is normally the login shell.
+// Otherwise utmp's fields win. /proc is not scanned at all when no session
+// needs it. Terminals that utmp does not list are not added, and sessions
+// are returned unchanged if /proc cannot be read.
+func fillFromProc(sessions []UserSession) []UserSession {
+	if !slices.ContainsFunc(sessions, needsProc) {
+		return sessions
+	}
+	procSessions, err := parseProc()
+	if err != nil {
+		logger.Debug("not filling sessions from /proc", "err", err)
+		return sessions
+	}
+	return mergeProcSessions(sessions, procSessions)
+}
+
+// needsProc reports whether fillFromProc has anything to complete in
+// session.
+func needsProc(session UserSession) bool {
+	return isNobody(session.User) || session.From == "" || session.LoginTime.Unix() <= 0 || session.Session == 0
+}
+
+// isNobody reports whether user is the unprivileged nobody account, by name
+// or as its usual UID, 65534.
+func isNobody(user string) bool {
+	return user == "nobody" || user == "65534"
+}
+
+// mergeProcSessions completes utmpSessions from procSessions, which hold one
+// session per terminal, as described for fillFromProc.
+func mergeProcSessions(utmpSessions, procSessions []UserSession) []UserSession {
+	byTTY := make(map[string]UserSession, len(procSessions))
+	for _, session := range procSessions {
+		byTTY[session.TTY] = session
+	}
+
+	merged := make([]UserSession, 0, len(utmpSessions))
+	for _, recorded := range utmpSessions {
+		session, ok := byTTY[recorded.TTY]
+		if !ok || !needsProc(recorded) {
+			merged = append(merged, recorded)
+			continue
+		}
+		if isNobody(recorded.User) && !isNobody(session.User) {
+			logger.Debug("using /proc user", "tty", recorded.TTY, "proc", session.User, "utmp", recorded.User)
+			recorded.User = session.User
+		}
+		if recorded.From == "" && session.From != "?" {
+			recorded.From = session.From
+		}
+		if recorded.LoginTime.Unix() <= 0 {
+			recorded.LoginTime = session.LoginTime
+		}
+		if recorded.Session == 0 {
+			recorded.Session = session.Session
+		}
+		merged = append(merged, recorded)
+	}
+	return merged
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMergeTTYs tests condensing each user's sessions into one row.
+func TestMergeTTYs(t *testing.T) {
+	login := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0", From: "10.0.0.1", IdleDuration: time.Hour, Idle: "1:00m", JCPU: "1.50s", PCPU: "0.10s", What: "vim", LoginTime: login.Add(time.Hour), Age: time.Hour},
+		{User: "bob", TTY: "tty1", IdleDuration: idleUnknown, Idle: "?", JCPU: "0.00s", PCPU: "0.00s", What: "-", LoginTime: login},
+		{User: "alice", TTY: "pts/1", From: "10.0.0.2", IdleDuration: 5 * time.Second, Idle: "5.00s", JCPU: "2.25s", PCPU: "0.50s", What: "top", LoginTime: login.Add(2 * time.Hour)},
+		{User: "alice", TTY: "pts/2", From: "10.0.0.3", IdleDuration: idleUnknown, Idle: "?", JCPU: "0.25s", PCPU: "0.00s", What: "-", LoginTime: login, Age: 2 * time.Hour},
+	}
+
+	merged := mergeTTYs(sessions)
+	if len(merged) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(merged))
+	}
+
+	alice := merged[0]
+	if alice.User != "alice" || alice.TTY != "pts/0,pts/1,pts/2" {
+		t.Errorf("Expected alice on pts/0,pts/1,pts/2, got %s on %s", alice.User, alice.TTY)
+	}
+	if alice.Idle != "5.00s" || alice.From != "10.0.0.2" || alice.PCPU != "0.50s" || alice.What != "top" {
+		t.Errorf("Expected the least idle session's fields, got %+v", alice)
+	}
+	if !alice.LoginTime.Equal(login) || alice.Age != 2*time.Hour {
+		t.Errorf("Expected the earliest login, got %v (age %v)", alice.LoginTime, alice.Age)
+	}
+	if alice.JCPU != "4.00s" {
+		t.Errorf("Expected JCPU '4.00s', got '%s'", alice.JCPU)
+	}
+
+	if bob := merged[1]; bob.TTY != "tty1" || bob.Idle != "?" || bob.JCPU != "0.00s" {
+		t.Errorf("Expected bob's single session unchanged, got %+v", bob)
+	}
+}
+
+// TestMergeTTYsUnknownCPU tests that JCPU is unknown if any session's is.
+func TestMergeTTYsUnknownCPU(t *testing.T) {
+	merged := mergeTTYs([]UserSession{
+		{User: "alice", TTY: "pts/0", JCPU: "1.00s"},
+		{User: "alice", TTY: "pts/1", JCPU: "?"},
+	})
+	if merged[0].JCPU != "?" {
+		t.Errorf("Expected JCPU '?', got '%s'", merged[0].JCPU)
+	}
+}
+
+// TestParseUtmpFillFromProc tests that the sessions read from utmp take the
+// fields utmp left empty from /proc, and the user of the terminal's first
+// process when utmp records nobody, but are otherwise left as recorded.
+func TestParseUtmpFillFromProc(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath, oldProcStatPath := procPath, procStatPath
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	procPath, procStatPath = dir, filepath.Join(dir, "stat")
+	logindSessionsDir = filepath.Join(dir, "sessions")
+	numericUsers = true
+	defer func() {
+		procPath, procStatPath = oldProcPath, oldProcStatPath
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		numericUsers = false
+	}()
+
+	if err := os.WriteFile(procStatPath, []byte("cpu  1 2 3 4\nbtime 1672502400\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// pts/1's first process is owned by nobody, pts/2's by 1001 and pts/4's
+	// by 1004; pts/5 has no utmp record
+	mockProcess(t, dir, 100, 65534, 34817, "-bash\x00")
+	mockProcess(t, dir, 200, 1001, 34818, "-bash\x00")
+	mockProcess(t, dir, 1000, 1002, 34818, "vim\x00")
+	mockProcess(t, dir, 400, 1004, 34820, "-bash\x00")
+	mockProcess(t, dir, 500, 1005, 34821, "-bash\x00")
+	environ := "SSH_CONNECTION=10.0.0.1 52000 10.0.0.9 22\x00"
+	if err := os.WriteFile(filepath.Join(dir, "100", "environ"), []byte(environ), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/1", "alice", "", 0)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/2", "nobody", "10.0.0.2", 1672509600)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/3", "carol", "10.0.0.3", 1672509600)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/4", "dave", "10.0.0.4", 1672509600)...)
+	utmpPaths = []string{writeTempFile(t, "utmp", data)}
+
+	sessions, _, err := parseUtmp()
+	if err != nil {
+		t.Fatalf("parseUtmp failed: %v", err)
+	}
+	if len(sessions) != 4 {
+		t.Fatalf("Expected the 4 utmp sessions, got %+v", sessions)
+	}
+	alice := sessions[0]
+	if alice.User != "alice" || alice.From != "10.0.0.1" || alice.Session != 100 {
+		t.Errorf("Expected alice on pts/1 from 10.0.0.1 in session 100, got %+v", alice)
+	}
+	if !alice.LoginTime.Equal(time.Unix(1672502400+70, 0)) {
+		t.Errorf("Expected the login time from /proc, got %v", alice.LoginTime)
+	}
+	if nobody := sessions[1]; nobody.User != "1001" || nobody.Session != 200 {
+		t.Errorf("Expected the user of pid 200 for nobody's session on pts/2, got %+v", nobody)
+	}
+	if carol := sessions[2]; carol.User != "carol" || carol.From != "10.0.0.3" || carol.Session != 0 {
+		t.Errorf("Expected carol's session unchanged, got %+v", carol)
+	}
+	if dave := sessions[3]; dave.User != "dave" {
+		t.Errorf("Expected utmp's user for dave's session, got %+v", dave)
+	}
+
+	// Not with another system's procfs
+	foreignProcfs = true
+	defer func() { foreignProcfs = false }()
+	sessions, _, err = parseUtmp()
+	if err != nil {
+		t.Fatalf("parseUtmp failed: %v", err)
+	}
+	if len(sessions) != 4 || sessions[0].From != "" || sessions[0].Session != 0 {
+		t.Errorf("Expected alice's session unchanged with --procfs, got %+v", sessions[0])
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "path/filepath"
+
+// muslLoaderPattern matches the dynamic loader of musl libc, as used by
+// Alpine. musl does not maintain utmp, so there /proc is the only source of
+// sessions.
+var muslLoaderPattern = "/lib/ld-musl-*.so.1"
+
+// isMusl reports whether the system's C library is musl.
+func isMusl() bool {
+	matches, err := filepath.Glob(muslLoaderPattern)
+	return err == nil && len(matches) > 0
+}
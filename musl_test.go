@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMuslHint tests that an empty utmp falls back to /proc, with a hint in
+// the method string on musl systems.
+func TestMuslHint(t *testing.T) {
+	dir := t.TempDir()
+	loader := writeTempFile(t, "ld-musl-x86_64.so.1", nil)
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	oldMuslLoaderPattern := muslLoaderPattern
+	oldProcPath := procPath
+	utmpPaths = []string{writeTempFile(t, "utmp", nil)}
+	logindSessionsDir = filepath.Join(dir, "sessions")
+	procPath = filepath.Join(dir, "proc")
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+		muslLoaderPattern = oldMuslLoaderPattern
+		procPath = oldProcPath
+	}()
+	if err := os.Mkdir(procPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		method  string
+	}{
+		{loader, "utmp not maintained (musl); using " + procPath},
+		{filepath.Join(dir, "ld-musl-*.so.1"), "using " + procPath},
+	}
+
+	for _, test := range tests {
+		muslLoaderPattern = test.pattern
+		_, method, err := parseUtmp()
+		if err != nil {
+			t.Fatalf("parseUtmp failed: %v", err)
+		}
+		if method != test.method {
+			t.Errorf("Expected method %q, got %q", test.method, method)
+		}
+	}
+
+	if _, err := (utmpSource{path: utmpPaths[0]}).Sessions(); err != errUtmpEmpty {
+		t.Errorf("Expected errUtmpEmpty for an empty utmp file, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// outputFile is the file written by --output. It keeps the first write
+// error, so that a full disk is reported once after rendering rather than by
+// every renderer.
+type outputFile struct {
+	f   *os.File
+	err error
+}
+
+// createOutput creates or truncates the --output file. Like shell
+// redirection, it is created readable by everyone, subject to the umask.
+func createOutput(path string) (*outputFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{f: f}, nil
+}
+
+// Write writes to the file, failing without writing after any earlier error.
+func (o *outputFile) Write(b []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.f.Write(b)
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// rewind empties the file for watch mode, so that each redraw replaces the
+// last rather than being appended to it.
+func (o *outputFile) rewind() error {
+	if err := o.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := o.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Close closes the file and returns the first error writing it, if any.
+func (o *outputFile) Close() error {
+	err := o.f.Close()
+	if o.err != nil {
+		err = o.err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", o.f.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// defaultPager is run when $PAGER is not set. The -R keeps color codes.
+const defaultPager = "less -R"
+
+// pager pipes output through an external pager process.
+type pager struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// startPager starts $PAGER (or defaultPager) with its output going to w,
+// normally the terminal, and returns a pager that feeds its input.
+func startPager(w io.Writer) (*pager, error) {
+	command := os.Getenv("PAGER")
+	if command == "" {
+		command = defaultPager
+	}
+
+	// Run through the shell so $PAGER may carry its own arguments
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &pager{cmd: cmd, stdin: stdin}, nil
+}
+
+// Write sends output to the pager. Once the user quits the pager, writes fail
+// with a broken pipe error, which callers are free to ignore.
+func (p *pager) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close signals the end of the output and waits for the user to quit the
+// pager.
+func (p *pager) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// exceedsTerminal reports whether w is a terminal with fewer rows than the
+// output about to be written to it.
+func exceedsTerminal(w io.Writer, rows int) bool {
+	height, ok := outputHeight(w)
+	return ok && rows > height
+}
+
+// outputHeight returns the height of the terminal w writes to. It reports
+// false if w is not a terminal, including any writer other than an *os.File.
+func outputHeight(w io.Writer) (int, bool) {
+	f, ok := w.(*os.File)
+	if !ok {
+		return 0, false
+	}
+	return terminalHeight(f)
+}
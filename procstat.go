@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procStatPath is the kernel statistics file holding the boot time.
+var procStatPath = "/proc/stat"
+
+// procPath is the directory holding the per-process directories read by
+// readProcStat and getTTYFromPID.
+var procPath = "/proc"
+
+// foreignProcfs is set when procPath is another system's procfs, given with
+// --procfs, whose processes and uptime are not those of the system go-w
+// runs on.
+var foreignProcfs bool
+
+// setProcfs rebases the procfs files go-w reads onto dir, for --procfs. Only
+// the files describing the inspected system move; the container detection
+// still looks at the procfs of the system go-w runs on.
+func setProcfs(dir string) {
+	procPath = dir
+	foreignProcfs = true
+	procStatPath = filepath.Join(dir, "stat")
+	uptimePath = filepath.Join(dir, "uptime")
+	loadAvgPath = filepath.Join(dir, "loadavg")
+}
+
+// procfsMounted reports whether a procfs is mounted at procPath, judged by
+// its self link, which every procfs has.
+func procfsMounted() bool {
+	_, err := os.Lstat(filepath.Join(procPath, "self"))
+	return err == nil
+}
+
+// clockTicks is the kernel's USER_HZ, the unit of the times in
+// /proc/<pid>/stat. It is 100 on every Linux architecture go-w runs on.
+const clockTicks = 100
+
+// procStat holds the fields of /proc/<pid>/stat that go-w uses. Times are in
+// clock ticks, as reported by the kernel.
+type procStat struct {
+	PID       int
+	Comm      string // Command name, without the surrounding parentheses
+	State     string
+	PPID      int
+	PGRP      int
+	Session   int
+	TTYNr     int // Device number of the controlling terminal, or 0
+	TPGID     int // Foreground process group of the controlling terminal
+	UTime     uint64
+	STime     uint64
+	StartTime uint64 // Time the process started after boot
+}
+
+// isKernelThread reports whether a process is a kernel thread, going by its
+// empty command line. Zombies, whose command line is also empty, count too.
+func isKernelThread(pid int) bool {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cmdline"))
+	return err == nil && len(data) == 0
+}
+
+// readProcStat reads and parses /proc/<pid>/stat.
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, fmt.Errorf("failed to read stat file: %w", err)
+	}
+	return parseProcStat(string(data))
+}
+
+// parseProcStat parses the contents of a /proc/<pid>/stat file. The command
+// name in the second field is wrapped in parentheses but may itself contain
+// spaces and parentheses, so it ends at the last ')' in the line rather than
+// at the first space.
+func parseProcStat(data string) (procStat, error) {
+	start := strings.IndexByte(data, '(')
+	end := strings.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("invalid stat format: missing command name")
+	}
+
+	// The remaining fields start with field 3 (state); starttime is field 22
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 20 {
+		return procStat{}, fmt.Errorf("invalid stat format: %d fields after command name", len(fields))
+	}
+	field := func(n int) string { return fields[n-3] }
+
+	stat := procStat{
+		Comm:  data[start+1 : end],
+		State: field(3),
+	}
+
+	var err error
+	ints := []struct {
+		n   int
+		dst *int
+	}{
+		{4, &stat.PPID}, {5, &stat.PGRP}, {6, &stat.Session}, {7, &stat.TTYNr}, {8, &stat.TPGID},
+	}
+	if stat.PID, err = strconv.Atoi(strings.TrimSpace(data[:start])); err != nil {
+		return procStat{}, fmt.Errorf("invalid pid: %w", err)
+	}
+	for _, f := range ints {
+		if *f.dst, err = strconv.Atoi(field(f.n)); err != nil {
+			return procStat{}, fmt.Errorf("invalid stat field %d: %w", f.n, err)
+		}
+	}
+
+	uints := []struct {
+		n   int
+		dst *uint64
+	}{
+		{14, &stat.UTime}, {15, &stat.STime}, {22, &stat.StartTime},
+	}
+	for _, f := range uints {
+		if *f.dst, err = strconv.ParseUint(field(f.n), 10, 64); err != nil {
+			return procStat{}, fmt.Errorf("invalid stat field %d: %w", f.n, err)
+		}
+	}
+
+	return stat, nil
+}
+
+// bootTime reads the system boot time from the "btime" line of /proc/stat.
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile(procStatPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			sec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime: %w", err)
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in %s", procStatPath)
+}
+
+// onlineCPUs returns the number of CPUs listed in /proc/stat, falling back
+// to the number usable by this process if it cannot be read.
+func onlineCPUs() int {
+	data, err := os.ReadFile(procStatPath)
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		// Per-CPU lines are "cpu0", "cpu1" and so on; "cpu" alone is the total
+		if len(line) > 3 && strings.HasPrefix(line, "cpu") && line[3] >= '0' && line[3] <= '9' {
+			n++
+		}
+	}
+	if n == 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// ttyNr encodes a device's major and minor numbers the way the kernel reports
+// the controlling terminal in the tty_nr field of /proc/<pid>/stat.
+func ttyNr(major, minor uint32) int {
+	return int(minor&0xff | major<<8 | (minor&^0xff)<<12)
+}
+
+// ttyName maps a tty_nr device number back to a terminal name. Only the
+// well-known terminal majors are recognized: pseudo-terminals (136-143),
+// virtual consoles and serial ports (4) and the system console (5, 1).
+func ttyName(nr int) (string, bool) {
+	major := nr >> 8 & 0xfff
+	minor := nr&0xff | nr>>12&^0xff
+	switch {
+	case major >= 136 && major <= 143:
+		return fmt.Sprintf("pts/%d", minor+(major-136)*256), true
+	case major == 4 && minor < 64:
+		return fmt.Sprintf("tty%d", minor), true
+	case major == 4:
+		return fmt.Sprintf("ttyS%d", minor-64), true
+	case major == 5 && minor == 1:
+		return "console", true
+	}
+	return "", false
+}
+
+// foregroundStart returns the start time of the newest process in the
+// foreground process group of the terminal with the given device number. It
+// stands in for the terminal's last activity when its access time cannot be
+// trusted.
+func foregroundStart(nr int, boot time.Time) (time.Time, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	var newest uint64
+	found := false
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil || stat.TTYNr != nr || stat.PGRP != stat.TPGID {
+			continue
+		}
+		if !found || stat.StartTime > newest {
+			newest, found = stat.StartTime, true
+		}
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("no foreground process on terminal %d", nr)
+	}
+	return procStartTime(boot, procStat{StartTime: newest}), nil
+}
+
+// procStartTime returns the wall-clock time a process started, given the
+// system boot time.
+func procStartTime(boot time.Time, stat procStat) time.Time {
+	return boot.Add(time.Duration(stat.StartTime) * time.Second / clockTicks)
+}
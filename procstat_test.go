@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestParseProcStat tests parsing stat lines, including command names with
+// spaces and parentheses.
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected procStat
+	}{
+		{
+			"1234 (bash) S 1000 1234 1234 34816 1300 4194304 100 0 0 0 12 3 0 0 20 0 1 0 5000 1000000 200 18446744073709551615\n",
+			procStat{PID: 1234, Comm: "bash", State: "S", PPID: 1000, PGRP: 1234, Session: 1234, TTYNr: 34816, TPGID: 1300, UTime: 12, STime: 3, StartTime: 5000},
+		},
+		{
+			"42 ((my proc)) R 1 42 42 0 -1 4194304 100 0 0 0 7 8 0 0 20 0 1 0 6000 1000000 200 18446744073709551615\n",
+			procStat{PID: 42, Comm: "(my proc)", State: "R", PPID: 1, PGRP: 42, Session: 42, TTYNr: 0, TPGID: -1, UTime: 7, STime: 8, StartTime: 6000},
+		},
+		{
+			"7 (a) b) c) S 1 7 7 0 -1 0 0 0 0 0 1 2 0 0 20 0 1 0 7000\n",
+			procStat{PID: 7, Comm: "a) b) c", State: "S", PPID: 1, PGRP: 7, Session: 7, TPGID: -1, UTime: 1, STime: 2, StartTime: 7000},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := parseProcStat(test.data)
+		if err != nil {
+			t.Errorf("parseProcStat(%q) failed: %v", test.data, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("parseProcStat(%q) = %+v; expected %+v", test.data, result, test.expected)
+		}
+	}
+
+	for _, data := range []string{"", "1234 bash S 1", "1234 (bash) S 1 2 3"} {
+		if _, err := parseProcStat(data); err == nil {
+			t.Errorf("parseProcStat(%q) succeeded; expected an error", data)
+		}
+	}
+}
+
+// TestProcStartTime tests deriving a process start time from /proc/stat.
+func TestProcStartTime(t *testing.T) {
+	oldProcStatPath := procStatPath
+	procStatPath = writeTempFile(t, "stat", []byte("cpu  1 2 3 4\nintr 5\nbtime 1672502400\nprocesses 100\n"))
+	defer func() {
+		procStatPath = oldProcStatPath
+	}()
+
+	boot, err := bootTime()
+	if err != nil {
+		t.Fatalf("bootTime failed: %v", err)
+	}
+	if !boot.Equal(time.Unix(1672502400, 0)) {
+		t.Errorf("Expected boot time %v, got %v", time.Unix(1672502400, 0), boot)
+	}
+
+	start := procStartTime(boot, procStat{StartTime: 6050})
+	if !start.Equal(time.Unix(1672502460, 5e8)) {
+		t.Errorf("Expected start time %v, got %v", time.Unix(1672502460, 5e8), start)
+	}
+}
+
+// TestTTYNr tests encoding device numbers as /proc/<pid>/stat tty_nr values.
+func TestTTYNr(t *testing.T) {
+	tests := []struct {
+		major, minor uint32
+		expected     int
+	}{
+		{136, 0, 34816},     // pts/0
+		{4, 1, 1025},        // tty1
+		{136, 300, 1083436}, // pts/300
+	}
+
+	for _, test := range tests {
+		result := ttyNr(test.major, test.minor)
+		if result != test.expected {
+			t.Errorf("ttyNr(%d, %d) = %d; expected %d", test.major, test.minor, result, test.expected)
+		}
+	}
+}
+
+// TestTTYName tests mapping tty_nr device numbers back to terminal names.
+func TestTTYName(t *testing.T) {
+	tests := []struct {
+		nr       int
+		expected string
+		ok       bool
+	}{
+		{34816, "pts/0", true},
+		{ttyNr(136, 300), "pts/300", true},
+		{ttyNr(137, 2), "pts/258", true},
+		{1025, "tty1", true},
+		{ttyNr(4, 65), "ttyS1", true},
+		{ttyNr(5, 1), "console", true},
+		{ttyNr(8, 1), "", false},
+	}
+
+	for _, test := range tests {
+		result, ok := ttyName(test.nr)
+		if result != test.expected || ok != test.ok {
+			t.Errorf("ttyName(%d) = %q, %v; expected %q, %v", test.nr, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+// TestGetTTYFromPID tests that the controlling terminal in the stat file wins
+// over open terminal file descriptors, which are only used as a fallback.
+func TestGetTTYFromPID(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	defer func() {
+		procPath = oldProcPath
+	}()
+
+	// Each process holds /dev/pts/3 open; tty_nr 34817 is pts/1 and 2049 is
+	// an unrecognized device
+	for pid, ttyNr := range map[string]int{"100": 34817, "200": 0, "300": 2049} {
+		fdDir := filepath.Join(dir, pid, "fd")
+		if err := os.MkdirAll(fdDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("/dev/pts/3", filepath.Join(fdDir, "0")); err != nil {
+			t.Fatal(err)
+		}
+		stat := fmt.Sprintf("%s (bash) S 1 %s %s %d -1 0 0 0 0 0 1 2 0 0 20 0 1 0 7000\n", pid, pid, pid, ttyNr)
+		if err := os.WriteFile(filepath.Join(dir, pid, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pid      int
+		expected string
+	}{
+		{100, "pts/1"},
+		{200, ""},
+		{300, "pts/3"},
+	}
+
+	for _, test := range tests {
+		tty, err := getTTYFromPID(test.pid)
+		if err != nil {
+			t.Errorf("getTTYFromPID(%d) failed: %v", test.pid, err)
+			continue
+		}
+		if tty != test.expected {
+			t.Errorf("getTTYFromPID(%d) = %q; expected %q", test.pid, tty, test.expected)
+		}
+	}
+}
+
+// TestGetTTYFromPIDPermission tests that an unreadable fd directory gives an
+// unknown terminal rather than an error that would hide the process.
+func TestGetTTYFromPIDPermission(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	oldReadFdDir := readFdDir
+	procPath = dir
+	readFdDir = func(name string) ([]os.DirEntry, error) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+	}
+	defer func() {
+		procPath = oldProcPath
+		readFdDir = oldReadFdDir
+	}()
+
+	// tty_nr 2049 is not a recognized terminal, so the fds are consulted
+	mockProcess(t, dir, 100, 54321, 2049, "-bash\x00")
+	tty, err := getTTYFromPID(100)
+	if err != nil || tty != "?" {
+		t.Errorf("getTTYFromPID = %q, %v; expected \"?\" and no error", tty, err)
+	}
+
+	// Other errors are still reported
+	readFdDir = os.ReadDir
+	if _, err := getTTYFromPID(100); err == nil {
+		t.Errorf("Expected an error for a missing fd directory")
+	}
+}
+
+// TestGetUserFromPIDNumeric tests that --numeric reports the UID from the
+// status file without looking it up.
+func TestGetUserFromPIDNumeric(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	numericUsers = true
+	defer func() {
+		procPath = oldProcPath
+		numericUsers = false
+	}()
+
+	status := "Name:\tbash\nState:\tS (sleeping)\nUid:\t54321\t54321\t54321\t54321\nGid:\t100\t100\t100\t100\n"
+	if err := os.MkdirAll(filepath.Join(dir, "100"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "100", "status"), []byte(status), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := getUserFromPID(100)
+	if err != nil {
+		t.Fatalf("getUserFromPID failed: %v", err)
+	}
+	if user != "54321" {
+		t.Errorf("Expected user '54321', got '%s'", user)
+	}
+}
+
+// mockProcess writes the stat, status and cmdline files of a process into
+// the procfs directory dir.
+func mockProcess(tb testing.TB, dir string, pid, uid, ttyNr int, cmdline string) {
+	tb.Helper()
+
+	pidDir := filepath.Join(dir, fmt.Sprint(pid))
+	if err := os.MkdirAll(pidDir, 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (bash) S 1 %d %d %d -1 0 0 0 0 0 1 2 0 0 20 0 1 0 7000\n", pid, pid, pid, ttyNr),
+		"status":  fmt.Sprintf("Name:\tbash\nUid:\t%d\t%d\t%d\t%d\n", uid, uid, uid, uid),
+		"cmdline": cmdline,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(pidDir, name), []byte(data), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// TestSetProcfs tests reading sessions, uptime and load from another procfs
+// mount.
+func TestSetProcfs(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath, oldProcStatPath := procPath, procStatPath
+	oldUptimePath, oldLoadAvgPath := uptimePath, loadAvgPath
+	numericUsers = true
+	defer func() {
+		procPath, procStatPath = oldProcPath, oldProcStatPath
+		uptimePath, loadAvgPath = oldUptimePath, oldLoadAvgPath
+		foreignProcfs = false
+		numericUsers = false
+	}()
+
+	files := map[string]string{
+		"uptime":  "12345.67 23456.78\n",
+		"loadavg": "0.15 0.10 0.05 1/100 12345\n",
+		"stat":    "cpu  1 2 3 4\nbtime 1672502400\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mockProcess(t, dir, 100, 54321, 34817, "-bash\x00")
+
+	setProcfs(dir)
+
+	info, err := getSystemInfo()
+	if err != nil {
+		t.Fatalf("getSystemInfo failed: %v", err)
+	}
+	if info.Uptime != "3:25:45" || info.LoadAvg != "0.15 0.10 0.05" {
+		t.Errorf("Expected the mock uptime and load, got '%s' and '%s'", info.Uptime, info.LoadAvg)
+	}
+
+	sessions, err := procSource{}.Sessions()
+	if err != nil {
+		t.Fatalf("parseProc failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].User != "54321" || sessions[0].TTY != "pts/1" {
+		t.Errorf("Expected one session for 54321 on pts/1, got %+v", sessions)
+	}
+	if !sessions[0].LoginTime.Equal(time.Unix(1672502400+70, 0)) {
+		t.Errorf("Expected a login time 70s after boot, got %v", sessions[0].LoginTime)
+	}
+	if name := (procSource{}).Name(); name != dir {
+		t.Errorf("Expected source name %q, got %q", dir, name)
+	}
+}
+
+// TestParseProcKernelThreads tests that kernel threads, with their empty
+// command lines, are skipped.
+func TestParseProcKernelThreads(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	numericUsers = true
+	defer func() {
+		procPath = oldProcPath
+		numericUsers = false
+	}()
+
+	mockProcess(t, dir, 2, 0, 0, "")
+	mockProcess(t, dir, 100, 54321, 34817, "-bash\x00")
+
+	if !isKernelThread(2) || isKernelThread(100) {
+		t.Errorf("Expected only pid 2 to be a kernel thread")
+	}
+	sessions, err := parseProc()
+	if err != nil {
+		t.Fatalf("parseProc failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].User != "54321" {
+		t.Errorf("Expected only the session of 54321, got %+v", sessions)
+	}
+}
+
+// TestParseProcOnePerTerminal tests that daemons without a controlling
+// terminal are not sessions, and that each terminal is one session, of its
+// lowest numbered process.
+func TestParseProcOnePerTerminal(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	numericUsers = true
+	defer func() {
+		procPath = oldProcPath
+		numericUsers = false
+	}()
+
+	mockProcess(t, dir, 1, 0, 0, "/sbin/init\x00")
+	mockProcess(t, dir, 523, 65534, 0, "/usr/sbin/dnsmasq\x00")
+	mockProcess(t, dir, 999, 1000, 34817, "-bash\x00")
+	mockProcess(t, dir, 1000, 1001, 34817, "vim\x00")
+	mockProcess(t, dir, 1200, 1002, 34818, "-bash\x00")
+
+	sessions, err := parseProc()
+	if err != nil {
+		t.Fatalf("parseProc failed: %v", err)
+	}
+	var got []string
+	for _, session := range sessions {
+		got = append(got, session.User+"@"+session.TTY)
+	}
+	if strings.Join(got, ",") != "1000@pts/1,1002@pts/2" {
+		t.Errorf("Expected 1000 on pts/1 and 1002 on pts/2, got %v", got)
+	}
+}
+
+// TestParseProcErrors tests that processes exiting during the scan are
+// skipped, and that a listing of /proc that fails part way still yields
+// the processes listed.
+func TestParseProcErrors(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	numericUsers = true
+	defer func() {
+		procPath = oldProcPath
+		readProcDir = os.ReadDir
+		numericUsers = false
+	}()
+
+	mockProcess(t, dir, 100, 54321, 34817, "-bash\x00")
+	mockProcess(t, dir, 101, 54322, 34818, "-bash\x00")
+	// Pid 101 exits between the listing and the read of its status
+	if err := os.Remove(filepath.Join(dir, "101", "status")); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := parseProc()
+	if err != nil {
+		t.Fatalf("parseProc failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].User != "54321" {
+		t.Errorf("Expected only the session of 54321, got %+v", sessions)
+	}
+
+	errListing := errors.New("listing interrupted")
+	readProcDir = func(name string) ([]fs.DirEntry, error) {
+		entries, _ := os.ReadDir(name)
+		return entries[:1], errListing
+	}
+	sessions, err = parseProc()
+	if err != nil {
+		t.Fatalf("parseProc with a partial listing failed: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("Expected the listed session, got %+v", sessions)
+	}
+
+	readProcDir = func(string) ([]fs.DirEntry, error) {
+		return nil, errListing
+	}
+	if _, err := parseProc(); !errors.Is(err, errListing) {
+		t.Errorf("Expected the listing error, got %v", err)
+	}
+}
+
+// BenchmarkParseProc measures parseProc over a synthetic /proc holding
+// mostly kernel threads, as on a typical server.
+func BenchmarkParseProc(b *testing.B) {
+	dir := b.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	numericUsers = true
+	defer func() {
+		procPath = oldProcPath
+		numericUsers = false
+	}()
+
+	for pid := 2; pid < 300; pid++ {
+		mockProcess(b, dir, pid, 0, 0, "")
+	}
+	for pid := 1000; pid < 1020; pid++ {
+		mockProcess(b, dir, pid, 54321, 34816+pid%10, "-bash\x00")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseProc(); err != nil {
+			b.Fatalf("parseProc failed: %v", err)
+		}
+	}
+}
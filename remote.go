@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// sshCommand is the ssh client run for --host. The system client is used
+// rather than an SSH library so that ~/.ssh/config, known_hosts and the agent
+// all behave as they do for the user's own ssh sessions.
+var sshCommand = "ssh"
+
+// remoteScript prints /proc/uptime and /proc/loadavg, one line each, followed
+// by the raw utmp file.
+const remoteScript = "cat /proc/uptime /proc/loadavg && { cat /run/utmp 2>/dev/null || cat /var/run/utmp; }"
+
+// fetchRemote runs remoteScript on host over ssh and returns its output.
+func fetchRemote(host string) ([]byte, error) {
+	// BatchMode fails instead of prompting, which would garble the output;
+	// "--" keeps a host starting with "-" from being read as an option
+	cmd := exec.Command(sshCommand, "-o", "BatchMode=yes", "--", host, remoteScript)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %w", host, err)
+	}
+	return out, nil
+}
+
+// parseRemote splits the output of remoteScript into the system information
+// and the sessions of the remote host. Idle times cannot be read remotely, so
+// every session's idle time is unknown.
+func parseRemote(data []byte) (SystemInfo, []UserSession, error) {
+	parts := bytes.SplitN(data, []byte("\n"), 3)
+	if len(parts) < 3 {
+		return SystemInfo{}, nil, fmt.Errorf("truncated remote output")
+	}
+
+	uptime, uptimeErr := parseUptime(string(parts[0]))
+	load, loadErr := parseLoadAverage(string(parts[1]))
+	info, err := newSystemInfo(uptime, uptimeErr, load, loadErr)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	sessions, err := parseUtmpReader(bytes.NewReader(parts[2]))
+	if err != nil {
+		return info, nil, err
+	}
+	for i := range sessions {
+		sessions[i].Idle = "?"
+		sessions[i].IdleDuration = idleUnknown
+		if !sessions[i].LoginTime.IsZero() {
+			sessions[i].Age = max(nowFunc().Sub(sessions[i].LoginTime), 0)
+		}
+	}
+	return info, sessions, nil
+}
+
+// gatherRemote collects the system information and sessions of host.
+func gatherRemote(host string) (SystemInfo, []UserSession, string, error) {
+	data, err := fetchRemote(host)
+	if err != nil {
+		return SystemInfo{}, nil, "", err
+	}
+	info, sessions, err := parseRemote(data)
+	if err != nil {
+		return info, nil, "", fmt.Errorf("%s: %w", host, err)
+	}
+	return info, sessions, "using ssh " + host, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseRemote tests splitting the output of remoteScript.
+func TestParseRemote(t *testing.T) {
+	data := []byte("12345.67 54321.00\n0.15 0.10 0.05 1/123 4567\n")
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672502400)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/1", "bob", "10.0.0.2", 1672545600)...)
+
+	info, sessions, err := parseRemote(data)
+	if err != nil {
+		t.Fatalf("parseRemote failed: %v", err)
+	}
+	if info.Uptime != "3:25:45" || info.LoadAvg != "0.15 0.10 0.05" {
+		t.Errorf("Expected uptime 3:25:45 and load 0.15 0.10 0.05, got %s and %s", info.Uptime, info.LoadAvg)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[1].User != "bob" || sessions[1].From != "10.0.0.2" || !sessions[1].LoginTime.Equal(time.Unix(1672545600, 0)) {
+		t.Errorf("Unexpected second session %+v", sessions[1])
+	}
+	for _, session := range sessions {
+		if session.Idle != "?" || session.IdleDuration != idleUnknown {
+			t.Errorf("Expected unknown idle time for %s, got %q", session.User, session.Idle)
+		}
+	}
+
+	if _, _, err := parseRemote([]byte("12345.67 54321.00\n")); err == nil {
+		t.Errorf("parseRemote of truncated output succeeded; expected an error")
+	}
+}
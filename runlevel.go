@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTargetPath is the symlink naming systemd's default target, reported
+// by --runlevel when utmp has no RUN_LVL record.
+var defaultTargetPath = "/etc/systemd/system/default.target"
+
+// runLevel is the current run level as recorded in utmp. A RUN_LVL record
+// stores the new level in the low byte of its pid and the previous level in
+// the next byte.
+type runLevel struct {
+	Level    rune
+	Previous rune // 0 if there was none
+	Event    LoginEvent
+}
+
+// currentRunLevel returns the last RUN_LVL record in events.
+func currentRunLevel(events []LoginEvent) (runLevel, bool) {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Type == RUN_LVL {
+			pid := events[i].PID
+			return runLevel{Level: rune(pid % 256), Previous: rune(pid / 256 % 256), Event: events[i]}, true
+		}
+	}
+	return runLevel{}, false
+}
+
+// displayRunLevel prints a run level the way "who -r" does.
+func displayRunLevel(w io.Writer, rl runLevel) {
+	line := fmt.Sprintf("run-level %c  %s", rl.Level, formatDate(rl.Event.Time))
+	if rl.Previous != 0 && rl.Previous != 'N' {
+		line += fmt.Sprintf("  last=%c", rl.Previous)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// printRunLevel prints the current run level from the first readable utmp
+// file, or systemd's default target if no run level is recorded, and returns
+// the exit code.
+func printRunLevel(w io.Writer) int {
+	var events []LoginEvent
+	var err error
+	for _, path := range utmpPaths {
+		if events, err = parseWtmpFile(path); err == nil {
+			break
+		}
+		logger.Debug("utmp file unavailable", "path", path, "err", err)
+	}
+
+	if rl, ok := currentRunLevel(events); ok {
+		displayRunLevel(w, rl)
+		return exitOK
+	}
+
+	if target, err := os.Readlink(defaultTargetPath); err == nil {
+		fmt.Fprintf(w, "default target %s\n", strings.TrimSuffix(filepath.Base(target), ".target"))
+		return exitOK
+	}
+
+	log.Printf("Error: no run level recorded in utmp")
+	return exitError
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRunLevel tests reading the current run level from RUN_LVL records.
+func TestRunLevel(t *testing.T) {
+	runLevelRecord := func(level, previous byte, sec int64) []byte {
+		record := mockUtmpRecord(RUN_LVL, "~", "runlevel", "6.1.0-13-amd64", sec)
+		record[4], record[5] = level, previous
+		return record
+	}
+
+	var data []byte
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-13-amd64", 1672502000)...)
+	data = append(data, runLevelRecord('3', 'N', 1672502100)...)
+	data = append(data, runLevelRecord('5', '3', 1672502400)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "", 1672502500)...)
+
+	oldUtmpPaths := utmpPaths
+	utmpPaths = []string{writeTempFile(t, "utmp", data)}
+	defer func() {
+		utmpPaths = oldUtmpPaths
+	}()
+
+	var buf bytes.Buffer
+	if code := printRunLevel(&buf); code != exitOK {
+		t.Fatalf("printRunLevel = %d; expected %d", code, exitOK)
+	}
+	if expected := "run-level 5  2022-12-31 16:00  last=3\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+// TestRunLevelDefaultTarget tests falling back to systemd's default target.
+func TestRunLevelDefaultTarget(t *testing.T) {
+	dir := t.TempDir()
+	oldUtmpPaths := utmpPaths
+	oldDefaultTargetPath := defaultTargetPath
+	utmpPaths = []string{filepath.Join(dir, "utmp")}
+	defaultTargetPath = filepath.Join(dir, "default.target")
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		defaultTargetPath = oldDefaultTargetPath
+	}()
+
+	if code := printRunLevel(&bytes.Buffer{}); code != exitError {
+		t.Errorf("printRunLevel without a run level = %d; expected %d", code, exitError)
+	}
+
+	if err := os.Symlink("/lib/systemd/system/graphical.target", defaultTargetPath); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if code := printRunLevel(&buf); code != exitOK {
+		t.Fatalf("printRunLevel = %d; expected %d", code, exitOK)
+	}
+	if expected := "default target graphical\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
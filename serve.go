@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveShutdownTimeout is how long --serve waits for requests in progress to
+// finish when interrupted.
+const serveShutdownTimeout = 5 * time.Second
+
+// serveResponse is the JSON document served by --serve: the system
+// information and the sessions, in the forms printed by --info-only and
+// --json.
+type serveResponse struct {
+	Info     systemInfoJSON `json:"info"`
+	Sessions []UserSession  `json:"sessions"`
+}
+
+// gatherFunc gathers the system information and sessions, as gather does.
+type gatherFunc func() (SystemInfo, []UserSession, string, error)
+
+// cachedGather wraps f so that it is called at most once per ttl, with the
+// callers in between sharing its result. A burst of concurrent callers waits
+// for one call rather than each making their own. Failures are not cached,
+// and a ttl of 0 disables caching altogether.
+func cachedGather(ttl time.Duration, f gatherFunc) gatherFunc {
+	if ttl <= 0 {
+		return f
+	}
+
+	var (
+		mu       sync.Mutex
+		info     SystemInfo
+		sessions []UserSession
+		method   string
+		expires  time.Time
+	)
+	return func() (SystemInfo, []UserSession, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if nowFunc().Before(expires) {
+			return info, sessions, method, nil
+		}
+
+		i, s, m, err := f()
+		if err != nil {
+			return i, s, m, err
+		}
+		info, sessions, method = i, s, m
+		expires = nowFunc().Add(ttl)
+		return info, sessions, method, nil
+	}
+}
+
+// serveHandler returns the --serve handler, which answers GET / with the
+// system information and sessions, gathered with the filters in opts. They
+// are gathered afresh for a request unless one in the last opts.ServeCache
+// already did.
+func serveHandler(opts options) http.Handler {
+	gatherOnce := cachedGather(opts.ServeCache, func() (SystemInfo, []UserSession, string, error) {
+		return gather(opts)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		info, sessions, _, err := gatherOnce()
+		if err != nil {
+			log.Printf("Error: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sessions == nil {
+			sessions = []UserSession{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if opts.Pretty {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(serveResponse{Info: newSystemInfoJSON(info), Sessions: sessions}); err != nil {
+			logger.Debug("failed to write response", "err", err)
+		}
+	})
+	return mux
+}
+
+// serve runs the --serve HTTP server on opts.Serve until interrupted, then
+// shuts it down gracefully, and returns the exit code.
+func serve(opts options) int {
+	ln, err := net.Listen("tcp", opts.Serve)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	fmt.Fprintf(os.Stderr, "go-w: serving on http://%s/\n", ln.Addr())
+
+	server := &http.Server{Handler: serveHandler(opts), ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(ln)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-errs:
+		log.Printf("Error: %v", err)
+		return exitError
+	case <-sigs:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestServeHandler tests the JSON document served by --serve and the
+// responses to other paths and methods.
+func TestServeHandler(t *testing.T) {
+	oldUtmpPaths := utmpPaths
+	oldLogindSessionsDir := logindSessionsDir
+	utmpPaths = []string{writeTempFile(t, "utmp", mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672502400))}
+	logindSessionsDir = t.TempDir()
+	defer func() {
+		utmpPaths = oldUtmpPaths
+		logindSessionsDir = oldLogindSessionsDir
+	}()
+
+	server := httptest.NewServer(serveHandler(options{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON response, got %s with %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	var body struct {
+		Info     systemInfoJSON `json:"info"`
+		Sessions []UserSession  `json:"sessions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode the response: %v", err)
+	}
+	if body.Info.Users != 1 || len(body.Sessions) != 1 || body.Sessions[0].User != "alice" {
+		t.Errorf("Expected alice's session and 1 user, got %+v", body)
+	}
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.status, resp.StatusCode)
+		}
+	}
+}
+
+// TestCachedGather tests that gathers are shared until the TTL expires, and
+// that failures and a zero TTL are not cached.
+func TestCachedGather(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	setNow(t, now)
+
+	calls := 0
+	var fail error
+	f := func() (SystemInfo, []UserSession, string, error) {
+		calls++
+		return SystemInfo{Users: calls}, nil, "", fail
+	}
+
+	cached := cachedGather(time.Second, f)
+	for _, test := range []struct {
+		advance time.Duration
+		fail    error
+		users   int
+	}{
+		{0, nil, 1},
+		{500 * time.Millisecond, nil, 1},
+		{time.Second, nil, 2},
+		{time.Second, errors.New("no utmp"), 3},
+		{0, nil, 4},
+		{0, nil, 4},
+	} {
+		now = now.Add(test.advance)
+		setNow(t, now)
+		fail = test.fail
+		info, _, _, err := cached()
+		if (err != nil) != (test.fail != nil) {
+			t.Errorf("Expected error %v, got %v", test.fail, err)
+		}
+		if info.Users != test.users {
+			t.Errorf("Expected the result of call %d, got %d", test.users, info.Users)
+		}
+	}
+
+	calls = 0
+	uncached := cachedGather(0, f)
+	uncached()
+	uncached()
+	if calls != 2 {
+		t.Errorf("Expected 2 calls without caching, got %d", calls)
+	}
+}
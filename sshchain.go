@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sshForwardedEnv lists the earlier hops of a login made through one or more
+// bastions, origin first, comma-separated like HTTP's X-Forwarded-For. sshd
+// only sees the last hop; a jump host can pass the rest along by setting it
+// with SetEnv, and the target accepting it with AcceptEnv. The variable is a
+// convention of go-w's, not of OpenSSH, and the user can set it to anything,
+// so it is only a hint.
+const sshForwardedEnv = "SSH_FORWARDED_FOR"
+
+// getSSHChain returns the hosts a process's SSH login came through, origin
+// first and the immediate client last, from its environment. The immediate
+// client is the first field of SSH_CONNECTION, or of the older SSH_CLIENT;
+// SSH_CONNECTION's third field is this host's own address, not a hop. It
+// returns no hosts for processes outside an SSH session.
+//
+// The environment is the user's to change, and whoever runs go-w is often
+// root, so hops that are not IP addresses or host names, which could carry
+// escape sequences for their terminal, are dropped.
+func getSSHChain(pid int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return nil, err
+	}
+	env := parseEnviron(data)
+
+	client := firstField(env["SSH_CONNECTION"])
+	if client == "" {
+		client = firstField(env["SSH_CLIENT"])
+	}
+	if client == "" {
+		return nil, nil
+	}
+	if !validHop(client) {
+		logger.Debug("ignoring invalid SSH client", "pid", pid, "client", printable(client))
+		return nil, nil
+	}
+
+	var chain []string
+	for _, hop := range strings.Split(env[sshForwardedEnv], ",") {
+		hop = strings.TrimSpace(hop)
+		if hop == "" {
+			continue
+		}
+		if !validHop(hop) {
+			logger.Debug("ignoring invalid SSH hop", "pid", pid, "hop", printable(hop))
+			continue
+		}
+		chain = append(chain, printable(hop))
+	}
+	return append(chain, printable(client)), nil
+}
+
+// validHop reports whether hop is an IP address, possibly with an IPv6 zone,
+// or a host name of letters, digits, hyphens and dots.
+func validHop(hop string) bool {
+	if _, err := netip.ParseAddr(hop); err == nil {
+		return true
+	}
+	if len(hop) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hop, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseEnviron parses the NUL-separated KEY=VALUE pairs of a
+// /proc/<pid>/environ file.
+func parseEnviron(data []byte) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		if key, value, ok := strings.Cut(string(entry), "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// firstField returns the first space-separated field of s, or "".
+func firstField(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestGetSSHChain tests reading the hops of an SSH login from a process's
+// environment.
+func TestGetSSHChain(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	defer func() { procPath = oldProcPath }()
+	procPath = dir
+
+	tests := []struct {
+		name    string
+		environ string
+		want    []string
+	}{
+		{"no ssh", "HOME=/root\x00TERM=xterm\x00", nil},
+		{"connection", "SSH_CONNECTION=203.0.113.5 51234 10.0.0.2 22\x00", []string{"203.0.113.5"}},
+		{"client only", "SSH_CLIENT=203.0.113.5 51234 22\x00", []string{"203.0.113.5"}},
+		{"connection wins", "SSH_CLIENT=198.51.100.1 1 22\x00SSH_CONNECTION=203.0.113.5 51234 10.0.0.2 22\x00", []string{"203.0.113.5"}},
+		{"forwarded", "SSH_FORWARDED_FOR=203.0.113.5, 192.0.2.7\x00SSH_CONNECTION=10.0.0.1 40000 10.0.0.2 22\x00", []string{"203.0.113.5", "192.0.2.7", "10.0.0.1"}},
+		{"forwarded without ssh", "SSH_FORWARDED_FOR=203.0.113.5\x00", nil},
+		{"host names", "SSH_FORWARDED_FOR=bastion-1.example.com\x00SSH_CONNECTION=fe80::1%eth0 40000 fe80::2 22\x00", []string{"bastion-1.example.com", "fe80::1%eth0"}},
+		{"escape in hop", "SSH_FORWARDED_FOR=\x1b[2J,192.0.2.7,-bad-\x00SSH_CONNECTION=10.0.0.1 40000 10.0.0.2 22\x00", []string{"192.0.2.7", "10.0.0.1"}},
+		{"escape in client", "SSH_FORWARDED_FOR=192.0.2.7\x00SSH_CONNECTION=\x1b]0;pwned\x07 40000 10.0.0.2 22\x00", nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := 100 + i
+			mockProcess(t, dir, pid, 0, 34816, "bash\x00")
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(pid), "environ"), []byte(tt.environ), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := getSSHChain(pid)
+			if err != nil {
+				t.Fatalf("getSSHChain() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("getSSHChain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := getSSHChain(999); err == nil {
+		t.Error("getSSHChain() of a missing process succeeded")
+	}
+}
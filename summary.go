@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sessionSummary is the digest of the sessions printed by --summary-line.
+type sessionSummary struct {
+	Sessions int // Terminals, counting each of a --merge-ttys row's
+	Users    int
+
+	// Busiest is the user with the most sessions, ties going to the one
+	// with the most JCPU and then the first listed, with their session
+	// count and total JCPU in seconds. It is empty if there are no
+	// sessions.
+	Busiest         string
+	BusiestSessions int
+	BusiestJCPU     float64
+}
+
+// summarizeSessions computes the --summary-line digest of sessions. JCPU
+// values that cannot be parsed count as zero.
+func summarizeSessions(sessions []UserSession) sessionSummary {
+	var summary sessionSummary
+	groups := groupSessions(sessions, func(s UserSession) string { return s.User })
+	summary.Users = len(groups)
+	for _, group := range groups {
+		count := 0
+		var jcpu float64
+		for _, session := range group.Sessions {
+			count += strings.Count(session.TTY, ",") + 1
+			if seconds, ok := parseCPUTime(session.JCPU); ok {
+				jcpu += seconds
+			}
+		}
+		summary.Sessions += count
+
+		if count > summary.BusiestSessions || (count == summary.BusiestSessions && jcpu > summary.BusiestJCPU) {
+			summary.Busiest = group.Key
+			summary.BusiestSessions = count
+			summary.BusiestJCPU = jcpu
+		}
+	}
+	return summary
+}
+
+// displaySummaryLine prints the --summary-line digest below the sessions:
+// how many sessions and users there are and who is busiest. Nothing is
+// printed without sessions.
+func displaySummaryLine(w io.Writer, sessions []UserSession, theme Theme) {
+	summary := summarizeSessions(sessions)
+	if summary.Sessions == 0 {
+		return
+	}
+	line := fmt.Sprintf("%s, %s; busiest: %s with %s, %.2fs JCPU",
+		plural(summary.Sessions, "session", "sessions"),
+		plural(summary.Users, "user", "users"),
+		summary.Busiest,
+		plural(summary.BusiestSessions, "session", "sessions"),
+		summary.BusiestJCPU,
+	)
+	fmt.Fprintln(w, paint(theme.Note, line))
+}
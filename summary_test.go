@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSummaryLine tests the session and user counts and the choice of the
+// busiest user.
+func TestSummaryLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []UserSession
+		expected string
+	}{
+		{"none", nil, ""},
+		{"most sessions", []UserSession{
+			{User: "alice", TTY: "pts/0", JCPU: "1.00s"},
+			{User: "bob", TTY: "pts/1", JCPU: "90.00s"},
+			{User: "alice", TTY: "pts/2", JCPU: "0.50s"},
+		}, "3 sessions, 2 users; busiest: alice with 2 sessions, 1.50s JCPU\n"},
+		{"tie on JCPU", []UserSession{
+			{User: "alice", TTY: "pts/0", JCPU: "1.00s"},
+			{User: "bob", TTY: "pts/1", JCPU: "90.00s"},
+		}, "2 sessions, 2 users; busiest: bob with 1 session, 90.00s JCPU\n"},
+		{"merged", []UserSession{
+			{User: "alice", TTY: "pts/0,pts/2", JCPU: "1.50s"},
+			{User: "bob", TTY: "pts/1", JCPU: "?"},
+		}, "3 sessions, 2 users; busiest: alice with 2 sessions, 1.50s JCPU\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		displaySummaryLine(&buf, test.sessions, themes["none"])
+		if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, buf.String())
+		}
+	}
+}
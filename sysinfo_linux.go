@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysinfoUptime returns the uptime reported by the sysinfo(2) system call,
+// in whole seconds.
+func sysinfoUptime() (time.Duration, error) {
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0, err
+	}
+	return time.Duration(info.Uptime) * time.Second, nil
+}
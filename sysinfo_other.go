@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// sysinfoUptime returns the uptime reported by the sysinfo(2) system call,
+// which only exists on Linux, so this always fails.
+func sysinfoUptime() (time.Duration, error) {
+	return 0, errors.ErrUnsupported
+}
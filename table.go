@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// tableCell is a value in a --table row and the color to draw it in, if any.
+type tableCell struct {
+	text  string
+	color *color.Color
+}
+
+// sessionTable returns the column headings and the cells of each session for
+// the --table view, in the same column order as displaySessions.
+func sessionTable(sessions []UserSession, opts options) ([]string, [][]tableCell) {
+	theme := opts.Theme
+
+	columns := []string{"USER", "TTY"}
+	if opts.SID {
+		columns = append(columns, "SID")
+	}
+	if opts.Seat {
+		columns = append(columns, "SEAT")
+	}
+	if opts.NProc {
+		columns = append(columns, "NPROC")
+	}
+	columns = append(columns, "FROM", "LOGIN@")
+	if opts.Age {
+		columns = append(columns, "AGE")
+	}
+	columns = append(columns, "IDLE", "JCPU", "PCPU", "WHAT")
+
+	rows := make([][]tableCell, 0, len(sessions))
+	for _, session := range sessions {
+		userColor, ttyColor := theme.sessionColors(session)
+		row := []tableCell{{truncate(session.User, opts.UserWidth), userColor}, {session.TTY, ttyColor}}
+		if opts.SID {
+			row = append(row, tableCell{session.SessionID(), nil})
+		}
+		if opts.Seat {
+			row = append(row, tableCell{session.Seat, nil})
+		}
+		if opts.NProc {
+			row = append(row, tableCell{strconv.Itoa(session.Procs), nil})
+		}
+		row = append(row,
+			tableCell{truncate(session.From, opts.Trunc), theme.From},
+			tableCell{session.LoginAt(), nil},
+		)
+		if opts.Age {
+			row = append(row, tableCell{session.AgeString(), nil})
+		}
+		row = append(row,
+			tableCell{session.Idle, theme.idleColor(session.IdleDuration, opts.IdleWarn, opts.IdleCrit)},
+			tableCell{session.JCPU, nil},
+			tableCell{session.PCPU, nil},
+			tableCell{session.What, nil},
+		)
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
+
+// displayTable draws the sessions in a table with box-drawing borders and a
+// separator under the column headings.
+func displayTable(w io.Writer, sessions []UserSession, opts options) {
+	columns, rows := sessionTable(sessions, opts)
+
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell.text))
+		}
+	}
+
+	border := func(left, middle, right string) {
+		segments := make([]string, len(widths))
+		for i, width := range widths {
+			segments[i] = strings.Repeat("─", width+2)
+		}
+		fmt.Fprintln(w, left+strings.Join(segments, middle)+right)
+	}
+	line := func(cells []tableCell) {
+		var b strings.Builder
+		b.WriteString("│")
+		for i, cell := range cells {
+			b.WriteString(" " + paintPadded(cell.color, cell.text, widths[i]) + " │")
+		}
+		fmt.Fprintln(w, b.String())
+	}
+
+	headings := make([]tableCell, len(columns))
+	for i, column := range columns {
+		headings[i] = tableCell{column, opts.Theme.Columns}
+	}
+
+	border("┌", "┬", "┐")
+	line(headings)
+	border("├", "┼", "┤")
+	for _, row := range rows {
+		line(row)
+	}
+	border("└", "┴", "┘")
+}
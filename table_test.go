@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestDisplayTable tests the bordered --table layout.
+func TestDisplayTable(t *testing.T) {
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0", From: "10.0.0.1", LoginTime: time.Unix(1672502400, 0), Idle: "5:07", JCPU: "0.00s", PCPU: "0.00s", What: "-"},
+		{User: "bob", TTY: "tty1", From: "", LoginTime: time.Unix(1672545600, 0), Idle: ".", JCPU: "0.00s", PCPU: "0.00s", What: "vim"},
+	}
+
+	var buf bytes.Buffer
+	displayTable(&buf, sessions, options{})
+
+	expected := "" +
+		"┌───────┬───────┬──────────┬────────┬──────┬───────┬───────┬──────┐\n" +
+		"│ USER  │ TTY   │ FROM     │ LOGIN@ │ IDLE │ JCPU  │ PCPU  │ WHAT │\n" +
+		"├───────┼───────┼──────────┼────────┼──────┼───────┼───────┼──────┤\n" +
+		"│ alice │ pts/0 │ 10.0.0.1 │ 16:00  │ 5:07 │ 0.00s │ 0.00s │ -    │\n" +
+		"│ bob   │ tty1  │          │ 04:00  │ .    │ 0.00s │ 0.00s │ vim  │\n" +
+		"└───────┴───────┴──────────┴────────┴──────┴───────┴───────┴──────┘\n"
+	if buf.String() != expected {
+		t.Errorf("Expected table:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
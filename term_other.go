@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// resizeSignals are delivered when the terminal window changes size. There
+// are none outside Unix.
+var resizeSignals []os.Signal
+
+// terminalHeight returns the number of rows of the terminal f is attached to.
+// Terminal sizes are only queried on Unix, so this always reports false.
+func terminalHeight(f *os.File) (int, bool) {
+	return 0, false
+}
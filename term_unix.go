@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// resizeSignals are delivered when the terminal window changes size.
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
+
+// terminalHeight returns the number of rows of the terminal f is attached to,
+// or false if f is not a terminal.
+func terminalHeight(f *os.File) (int, bool) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Row == 0 {
+		return 0, false
+	}
+	return int(ws.Row), true
+}
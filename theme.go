@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Theme holds the colors of each part of the default output. A nil color
+// leaves that part uncolored.
+type Theme struct {
+	Time    *color.Color // Current time in the header
+	Uptime  *color.Color // Uptime in the header
+	Load    *color.Color // Load averages in the header
+	Columns *color.Color // Column headings
+	User    *color.Color
+	TTY     *color.Color
+	From    *color.Color // FROM column and --group-by headings
+	Note    *color.Color // Informational lines such as "no users logged in"
+
+	// IdleOK, IdleWarn and IdleCrit color the IDLE column below
+	// --idle-warn, below --idle-crit and beyond it.
+	IdleOK, IdleWarn, IdleCrit *color.Color
+
+	// New and Gone color the USER and TTY of sessions that --watch-diff
+	// marks as logged in or out since the last redraw.
+	New, Gone *color.Color
+}
+
+// themes are the themes selectable with --theme.
+var themes = map[string]Theme{
+	"dark": {
+		Time:     color.New(color.FgCyan),
+		Uptime:   color.New(color.FgYellow),
+		Load:     color.New(color.FgYellow),
+		Columns:  color.New(color.FgHiWhite),
+		User:     color.New(color.FgGreen),
+		TTY:      color.New(color.FgBlue),
+		From:     color.New(color.FgMagenta),
+		Note:     color.New(color.Faint),
+		IdleOK:   color.New(color.FgGreen),
+		IdleWarn: color.New(color.FgYellow),
+		IdleCrit: color.New(color.FgRed),
+		New:      color.New(color.FgHiGreen, color.Bold),
+		Gone:     color.New(color.FgRed, color.CrossedOut),
+	},
+	// Yellow, cyan and bright white are hard to read on a light
+	// background, so the light theme avoids them
+	"light": {
+		Time:     color.New(color.FgBlue),
+		Uptime:   color.New(color.FgMagenta),
+		Load:     color.New(color.FgMagenta),
+		Columns:  color.New(color.Bold),
+		User:     color.New(color.FgGreen),
+		TTY:      color.New(color.FgBlue),
+		From:     color.New(color.FgMagenta),
+		Note:     color.New(color.Faint),
+		IdleOK:   color.New(color.FgGreen),
+		IdleWarn: color.New(color.FgMagenta),
+		IdleCrit: color.New(color.FgRed),
+		New:      color.New(color.FgGreen, color.Bold),
+		Gone:     color.New(color.FgRed, color.CrossedOut),
+	},
+	"none": {},
+}
+
+// lookupTheme returns the theme with the given name.
+func lookupTheme(name string) (Theme, error) {
+	theme, ok := themes[name]
+	if !ok {
+		names := make([]string, 0, len(themes))
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Theme{}, fmt.Errorf("unknown theme %q: must be one of %s", name, strings.Join(names, ", "))
+	}
+	return theme, nil
+}
+
+// idleColor picks the color for an idle time: IdleOK below warn, IdleWarn
+// below crit, and IdleCrit beyond that. Unknown idle times are not colored.
+func (t Theme) idleColor(idle, warn, crit time.Duration) *color.Color {
+	switch {
+	case idle == idleUnknown:
+		return nil
+	case idle < warn:
+		return t.IdleOK
+	case idle < crit:
+		return t.IdleWarn
+	default:
+		return t.IdleCrit
+	}
+}
+
+// statusIcon returns the --status-icons marker for an idle time, in the
+// same bands as idleColor: a dot for active and idle sessions, colored like
+// the IDLE column, and a clock for long-idle ones. Without color, whether
+// from --no-color or a theme that leaves IDLE uncolored, the bands are told
+// apart by ASCII markers instead: "*", "." and "z". Unknown idle times get a
+// blank marker.
+func (t Theme) statusIcon(idle, warn, crit time.Duration) string {
+	c := t.idleColor(idle, warn, crit)
+	var glyph, ascii string
+	switch {
+	case idle == idleUnknown:
+		return " "
+	case idle < warn:
+		glyph, ascii = "●", "*"
+	case idle < crit:
+		glyph, ascii = "●", "."
+	default:
+		glyph, ascii = "◷", "z"
+	}
+	if color.NoColor || c == nil {
+		return ascii
+	}
+	return c.Sprint(glyph)
+}
+
+// sessionColors returns the colors of a session's USER and TTY: the theme's,
+// or New or Gone for sessions --watch-diff marks as logged in or out.
+func (t Theme) sessionColors(s UserSession) (user, tty *color.Color) {
+	switch s.Change {
+	case changeNew:
+		return t.New, t.New
+	case changeGone:
+		return t.Gone, t.Gone
+	}
+	return t.User, t.TTY
+}
+
+// paint colors s with c, or returns it unchanged if c is nil.
+func paint(c *color.Color, s string) string {
+	if c == nil {
+		return s
+	}
+	return c.Sprint(s)
+}
+
+// paintPadded pads s with spaces to width runes and then colors it with c.
+// Padding first keeps columns aligned, since the color escapes would
+// otherwise count towards the width.
+func paintPadded(c *color.Color, s string, width int) string {
+	return paint(c, fmt.Sprintf("%-*s", width, s))
+}
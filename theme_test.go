@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// TestLookupTheme tests selecting themes by name.
+func TestLookupTheme(t *testing.T) {
+	for _, name := range []string{"dark", "light", "none"} {
+		if _, err := lookupTheme(name); err != nil {
+			t.Errorf("lookupTheme(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := lookupTheme("solarized"); err == nil {
+		t.Errorf("lookupTheme(%q) succeeded; expected an error", "solarized")
+	}
+
+	none, _ := lookupTheme("none")
+	if s := paint(none.User, "alice"); s != "alice" {
+		t.Errorf("Expected the none theme to leave text unchanged, got %q", s)
+	}
+}
+
+// TestPaintPadded tests that padding is applied inside the color escapes.
+func TestPaintPadded(t *testing.T) {
+	oldNoColor := color.NoColor
+	color.NoColor = false
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	result := paintPadded(color.New(color.FgGreen), "alice", 8)
+	if expected := "\x1b[32malice   \x1b[0m"; result != expected {
+		t.Errorf("paintPadded = %q; expected %q", result, expected)
+	}
+	if result := paintPadded(nil, "jöse", 6); result != "jöse  " {
+		t.Errorf("paintPadded(nil) = %q; expected %q", result, "jöse  ")
+	}
+}
+
+// TestStatusIcon tests the --status-icons markers with and without color.
+func TestStatusIcon(t *testing.T) {
+	oldNoColor := color.NoColor
+	defer func() {
+		color.NoColor = oldNoColor
+	}()
+
+	tests := []struct {
+		idle  time.Duration
+		glyph string
+		ascii string
+	}{
+		{10 * time.Second, "●", "*"},
+		{10 * time.Minute, "●", "."},
+		{2 * time.Hour, "◷", "z"},
+		{idleUnknown, " ", " "},
+	}
+
+	for _, test := range tests {
+		color.NoColor = false
+		icon := themes["dark"].statusIcon(test.idle, time.Minute, time.Hour)
+		if stripped := ansiPattern.ReplaceAllString(icon, ""); stripped != test.glyph {
+			t.Errorf("statusIcon(%v) = %q; expected %q", test.idle, stripped, test.glyph)
+		}
+		if icon := themes["none"].statusIcon(test.idle, time.Minute, time.Hour); icon != test.ascii {
+			t.Errorf("statusIcon(%v) without theme colors = %q; expected %q", test.idle, icon, test.ascii)
+		}
+
+		color.NoColor = true
+		if icon := themes["dark"].statusIcon(test.idle, time.Minute, time.Hour); icon != test.ascii {
+			t.Errorf("statusIcon(%v) with --no-color = %q; expected %q", test.idle, icon, test.ascii)
+		}
+	}
+}
+
+// TestSessionColors tests the USER and TTY colors of sessions marked by
+// --watch-diff.
+func TestSessionColors(t *testing.T) {
+	theme := themes["dark"]
+	tests := []struct {
+		change    sessionChange
+		user, tty *color.Color
+	}{
+		{changeNone, theme.User, theme.TTY},
+		{changeNew, theme.New, theme.New},
+		{changeGone, theme.Gone, theme.Gone},
+	}
+	for _, test := range tests {
+		user, tty := theme.sessionColors(UserSession{Change: test.change})
+		if user != test.user || tty != test.tty {
+			t.Errorf("sessionColors for change %d returned the wrong colors", test.change)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcInfo describes a process shown by --tree.
+type ProcInfo struct {
+	PID     int
+	PPID    int
+	Command string // Command line, or the command name for kernel threads
+}
+
+// processesForTTY returns the processes whose controlling terminal is tty,
+// sorted by pid.
+func processesForTTY(tty string) ([]ProcInfo, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	var procs []ProcInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil || stat.TTYNr == 0 {
+			continue
+		}
+		if name, ok := ttyName(stat.TTYNr); !ok || name != tty {
+			continue
+		}
+		procs = append(procs, ProcInfo{PID: pid, PPID: stat.PPID, Command: procCommand(pid, stat.Comm)})
+	}
+
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs, nil
+}
+
+// processCounts returns the number of processes attached to each terminal,
+// by the same controlling terminal detection as processesForTTY.
+func processCounts() (map[string]int, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procPath, err)
+	}
+
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil || stat.TTYNr == 0 {
+			continue
+		}
+		if name, ok := ttyName(stat.TTYNr); ok {
+			counts[name]++
+		}
+	}
+	return counts, nil
+}
+
+// countProcesses sets the Procs field of each session from one scan of
+// /proc. A session listing several terminals, as with --merge-ttys, counts
+// the processes on all of them.
+func countProcesses(sessions []UserSession) error {
+	counts, err := processCounts()
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		sessions[i].Procs = 0
+		for _, tty := range strings.Split(sessions[i].TTY, ",") {
+			sessions[i].Procs += counts[tty]
+		}
+	}
+	return nil
+}
+
+// procCommand returns the command line of a process with its arguments
+// joined by spaces, or comm if the command line is empty or unreadable.
+func procCommand(pid int, comm string) string {
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return comm
+	}
+	return string(bytes.ReplaceAll(bytes.TrimRight(data, "\x00"), []byte{0}, []byte{' '}))
+}
+
+// displayProcessTree prints procs as a tree indented under a session row.
+// Processes whose parent is not among procs, such as the login shell, are
+// the roots.
+func displayProcessTree(w io.Writer, procs []ProcInfo) {
+	children := make(map[int][]ProcInfo)
+	known := make(map[int]bool, len(procs))
+	for _, p := range procs {
+		known[p.PID] = true
+	}
+	var roots []ProcInfo
+	for _, p := range procs {
+		if known[p.PPID] {
+			children[p.PPID] = append(children[p.PPID], p)
+		} else {
+			roots = append(roots, p)
+		}
+	}
+
+	var walk func(p ProcInfo, depth int)
+	walk = func(p ProcInfo, depth int) {
+		fmt.Fprintf(w, "  %s└─ %d %s\n", strings.Repeat("   ", depth), p.PID, p.Command)
+		for _, child := range children[p.PID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestProcessesForTTY tests finding and drawing the processes on a terminal.
+func TestProcessesForTTY(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	defer func() {
+		procPath = oldProcPath
+	}()
+
+	// pts/0 is tty_nr 34816 and pts/1 is 34817
+	procs := []struct {
+		pid, ppid, ttyNr int
+		comm, cmdline    string
+	}{
+		{100, 1, 34816, "bash", "-bash\x00"},
+		{101, 100, 34816, "vim", "vim\x00notes.txt\x00"},
+		{102, 100, 34816, "sleep", ""},
+		{200, 1, 34817, "bash", "-bash\x00"},
+		{300, 2, 0, "kworker/0:1", ""},
+	}
+	for _, p := range procs {
+		pidDir := filepath.Join(dir, fmt.Sprint(p.pid))
+		if err := os.MkdirAll(pidDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		stat := fmt.Sprintf("%d (%s) S %d %d %d %d -1 0 0 0 0 0 1 2 0 0 20 0 1 0 7000\n", p.pid, p.comm, p.ppid, p.pid, p.pid, p.ttyNr)
+		if err := os.WriteFile(filepath.Join(pidDir, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pidDir, "cmdline"), []byte(p.cmdline), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := processesForTTY("pts/0")
+	if err != nil {
+		t.Fatalf("processesForTTY failed: %v", err)
+	}
+	expected := []ProcInfo{
+		{PID: 100, PPID: 1, Command: "-bash"},
+		{PID: 101, PPID: 100, Command: "vim notes.txt"},
+		{PID: 102, PPID: 100, Command: "sleep"},
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Fatalf("processesForTTY(pts/0) = %v; expected %v", result, expected)
+	}
+
+	var buf bytes.Buffer
+	displayProcessTree(&buf, result)
+	tree := "" +
+		"  └─ 100 -bash\n" +
+		"     └─ 101 vim notes.txt\n" +
+		"     └─ 102 sleep\n"
+	if buf.String() != tree {
+		t.Errorf("Expected tree:\n%s\ngot:\n%s", tree, buf.String())
+	}
+}
+
+// TestCountProcesses tests counting the processes on each session's
+// terminals.
+func TestCountProcesses(t *testing.T) {
+	dir := t.TempDir()
+	oldProcPath := procPath
+	procPath = dir
+	defer func() {
+		procPath = oldProcPath
+	}()
+
+	// pts/0 is tty_nr 34816 and pts/1 is 34817
+	mockProcess(t, dir, 100, 1000, 34816, "-bash\x00")
+	mockProcess(t, dir, 101, 1000, 34816, "vim\x00")
+	mockProcess(t, dir, 200, 1001, 34817, "-bash\x00")
+	mockProcess(t, dir, 300, 0, 0, "")
+
+	sessions := []UserSession{{TTY: "pts/0"}, {TTY: "pts/1"}, {TTY: "pts/0,pts/1"}, {TTY: "tty1"}}
+	if err := countProcesses(sessions); err != nil {
+		t.Fatalf("countProcesses failed: %v", err)
+	}
+	for i, expected := range []int{2, 1, 3, 0} {
+		if sessions[i].Procs != expected {
+			t.Errorf("Expected %d processes on %s, got %d", expected, sessions[i].TTY, sessions[i].Procs)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// systemW is the system w(1) that --verify compares against.
+var systemW = "w"
+
+// sessionKey identifies a session for --verify by its user and terminal.
+type sessionKey struct {
+	User string
+	TTY  string
+}
+
+// parseSystemW returns the user and terminal of each session listed by
+// "w -h", which prints one line per session with no header.
+func parseSystemW(output string) []sessionKey {
+	var keys []sessionKey
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			keys = append(keys, sessionKey{fields[0], fields[1]})
+		}
+	}
+	return keys
+}
+
+// verifySessions compares sessions with those listed by the system w, for
+// catching parsing differences between distributions, and writes any
+// discrepancies to w. Without a system w the check is skipped.
+func verifySessions(w io.Writer, sessions []UserSession) {
+	path, err := exec.LookPath(systemW)
+	if err != nil {
+		logger.Debug("skipping --verify", "err", err)
+		return
+	}
+
+	// procps truncates user names to 8 characters unless told otherwise
+	cmd := exec.Command(path, "-h")
+	cmd.Env = append(os.Environ(), "PROCPS_USERLEN=32")
+	output, err := cmd.Output()
+	if err != nil {
+		fmt.Fprintf(w, "verify: %s failed: %v\n", path, err)
+		return
+	}
+	theirs := parseSystemW(string(output))
+
+	// Count each user and terminal pair, up for ours and down for theirs
+	counts := make(map[sessionKey]int)
+	var order []sessionKey
+	count := func(key sessionKey, n int) {
+		if _, ok := counts[key]; !ok {
+			order = append(order, key)
+		}
+		counts[key] += n
+	}
+	for _, session := range sessions {
+		count(sessionKey{session.User, session.TTY}, 1)
+	}
+	for _, key := range theirs {
+		count(key, -1)
+	}
+
+	if len(sessions) != len(theirs) {
+		fmt.Fprintf(w, "verify: go-w found %d sessions, %s found %d\n", len(sessions), path, len(theirs))
+	}
+	for _, key := range order {
+		switch n := counts[key]; {
+		case n > 0:
+			fmt.Fprintf(w, "verify: only go-w lists %s on %s\n", key.User, key.TTY)
+		case n < 0:
+			fmt.Fprintf(w, "verify: only %s lists %s on %s\n", path, key.User, key.TTY)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVerifySessions tests reporting the differences from the system w.
+func TestVerifySessions(t *testing.T) {
+	fakeW := filepath.Join(t.TempDir(), "w")
+	script := "#!/bin/sh\n" +
+		"echo 'alice    pts/0    10.0.0.1         09:00    5.00s  0.10s  0.00s -bash'\n" +
+		"echo 'alice    :0       :0               08:00   ?xdm?   1:02m  0.00s /usr/libexec/gdm-x-session'\n"
+	if err := os.WriteFile(fakeW, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	oldSystemW := systemW
+	systemW = fakeW
+	defer func() {
+		systemW = oldSystemW
+	}()
+
+	sessions := []UserSession{
+		{User: "alice", TTY: "pts/0"},
+		{User: "bob", TTY: "pts/1"},
+	}
+	var buf bytes.Buffer
+	verifySessions(&buf, sessions)
+	expected := "verify: only go-w lists bob on pts/1\n" +
+		"verify: only " + fakeW + " lists alice on :0\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	verifySessions(&buf, append(sessions[:1], UserSession{User: "alice", TTY: ":0"}))
+	if buf.Len() != 0 {
+		t.Errorf("Expected no differences, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	systemW = filepath.Join(t.TempDir(), "missing")
+	verifySessions(&buf, sessions)
+	if buf.Len() != 0 {
+		t.Errorf("Expected the check to be skipped without a system w, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Terminal control sequences used by watch mode.
+const (
+	enterAltScreen = "\x1b[?1049h"
+	leaveAltScreen = "\x1b[?1049l"
+	hideCursor     = "\x1b[?25l"
+	showCursor     = "\x1b[?25h"
+	clearScreen    = "\x1b[H\x1b[2J"
+)
+
+// watch redraws the output every opts.Watch until interrupted. When w is a
+// terminal, the output is drawn on the alternate screen with the cursor
+// hidden, and both are restored on exit, including on SIGINT and SIGTERM. A
+// terminal resize redraws immediately so the layout follows the new size.
+func watch(w io.Writer, opts options) int {
+	_, interactive := outputHeight(w)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, resizeSignals...)...)
+	defer signal.Stop(sigs)
+
+	if interactive {
+		fmt.Fprint(w, enterAltScreen+hideCursor)
+		defer fmt.Fprint(w, showCursor+leaveAltScreen)
+	}
+
+	var diff *watchDiff
+	if opts.WatchDiff {
+		diff = &watchDiff{}
+	}
+
+	ticker := time.NewTicker(opts.Watch)
+	defer ticker.Stop()
+
+	for {
+		if err := redraw(w, opts, interactive, diff); err != nil {
+			log.Printf("Error: %v", err)
+			return exitError
+		}
+
+		select {
+		case <-ticker.C:
+		case sig := <-sigs:
+			if isResizeSignal(sig) {
+				continue
+			}
+			return exitOK
+		}
+	}
+}
+
+// sessionChange is how a session changed since the previous --watch-diff
+// redraw.
+type sessionChange int
+
+const (
+	changeNone sessionChange = iota
+	changeNew                // Logged in since the previous redraw
+	changeGone               // Logged out since the previous redraw
+)
+
+// watchKey identifies a session across redraws. The login time tells a new
+// login on a reused terminal apart from the session before it.
+type watchKey struct {
+	user, tty string
+	login     int64
+}
+
+// watchDiff holds the sessions of the previous --watch-diff redraw.
+type watchDiff struct {
+	prev    []UserSession
+	started bool
+}
+
+// apply marks the sessions that were not in the previous redraw as new, and
+// appends those of the previous redraw that are gone, marked as such and
+// with "logged out" as WHAT. A gone session is shown in one redraw only.
+// Nothing is marked in the first redraw, which has no previous one.
+func (d *watchDiff) apply(sessions []UserSession) []UserSession {
+	key := func(s UserSession) watchKey { return watchKey{s.User, s.TTY, s.LoginTime.Unix()} }
+	prev := make(map[watchKey]bool, len(d.prev))
+	for _, session := range d.prev {
+		prev[key(session)] = true
+	}
+	cur := make(map[watchKey]bool, len(sessions))
+	marked := make([]UserSession, 0, len(sessions))
+	for _, session := range sessions {
+		cur[key(session)] = true
+		if d.started && !prev[key(session)] {
+			session.Change = changeNew
+		}
+		marked = append(marked, session)
+	}
+	for _, session := range d.prev {
+		if !cur[key(session)] {
+			session.Change = changeGone
+			session.What = "logged out"
+			marked = append(marked, session)
+		}
+	}
+
+	d.prev, d.started = sessions, true
+	return marked
+}
+
+// redraw gathers and renders one frame of watch output. The frame is
+// rendered into a buffer first so the screen is only cleared once the new
+// output is ready, which avoids flicker. With diff set, the sessions are
+// marked by how they changed since the previous frame.
+func redraw(w io.Writer, opts options, interactive bool, diff *watchDiff) error {
+	info, sessions, method, err := gather(opts)
+	if err != nil {
+		return err
+	}
+	if diff != nil {
+		sessions = diff.apply(sessions)
+	}
+
+	var buf bytes.Buffer
+	if interactive {
+		buf.WriteString(clearScreen)
+	} else if out, ok := w.(*outputFile); ok {
+		// Keep only the latest redraw in an --output file
+		if err := out.rewind(); err != nil {
+			return err
+		}
+	}
+	if err := render(&buf, opts, info, sessions, method); err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// isResizeSignal reports whether sig is one of resizeSignals.
+func isResizeSignal(sig os.Signal) bool {
+	for _, s := range resizeSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
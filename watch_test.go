@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWatchDiff tests marking sessions that logged in or out between
+// --watch-diff redraws.
+func TestWatchDiff(t *testing.T) {
+	login := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	alice := UserSession{User: "alice", TTY: "pts/0", What: "vim", LoginTime: login}
+	bob := UserSession{User: "bob", TTY: "pts/1", What: "-", LoginTime: login}
+	// A new login on alice's terminal
+	alice2 := UserSession{User: "alice", TTY: "pts/0", What: "-", LoginTime: login.Add(time.Hour)}
+
+	type row struct {
+		user   string
+		what   string
+		change sessionChange
+	}
+	steps := []struct {
+		sessions []UserSession
+		expected []row
+	}{
+		{[]UserSession{alice}, []row{{"alice", "vim", changeNone}}},
+		{[]UserSession{alice, bob}, []row{{"alice", "vim", changeNone}, {"bob", "-", changeNew}}},
+		{[]UserSession{bob}, []row{{"bob", "-", changeNone}, {"alice", "logged out", changeGone}}},
+		{[]UserSession{bob, alice2}, []row{{"bob", "-", changeNone}, {"alice", "-", changeNew}}},
+		{[]UserSession{bob, alice2}, []row{{"bob", "-", changeNone}, {"alice", "-", changeNone}}},
+	}
+
+	var diff watchDiff
+	for i, step := range steps {
+		marked := diff.apply(step.sessions)
+		if len(marked) != len(step.expected) {
+			t.Fatalf("Redraw %d: expected %d sessions, got %+v", i, len(step.expected), marked)
+		}
+		for j, session := range marked {
+			if got := (row{session.User, session.What, session.Change}); got != step.expected[j] {
+				t.Errorf("Redraw %d, session %d: expected %+v, got %+v", i, j, step.expected[j], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"time"
+)
+
+// wtmpPath is the login history file, made of utmp records appended in
+// chronological order.
+var wtmpPath = "/var/log/wtmp"
+
+// btmpPath records failed login attempts in the same format as wtmp. It is
+// normally readable only by root.
+var btmpPath = "/var/log/btmp"
+
+// LoginEvent is a record from wtmp: a login, logout, boot or run-level
+// change.
+type LoginEvent struct {
+	Type int16
+	PID  int32 // For RUN_LVL records, the new and previous run levels
+	User string
+	TTY  string
+	Host string // Remote host, or the kernel version for BOOT_TIME records
+	Time time.Time
+}
+
+// parseWtmpFile reads every record of a wtmp file, oldest first.
+func parseWtmpFile(path string) ([]LoginEvent, error) {
+	file, err := openUtmp(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wtmp file: %w", err)
+	}
+	defer file.Close()
+
+	return parseWtmpReader(bufio.NewReader(file))
+}
+
+// parseWtmpReader reads wtmp records from r until EOF.
+func parseWtmpReader(r io.Reader) ([]LoginEvent, error) {
+	var events []LoginEvent
+
+	buf := recordBuffer()
+	var entry utmp
+	for {
+		if _, err := io.ReadFull(r, buf[:recordSize]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read wtmp entry: %w", err)
+		}
+		decodeUtmp(buf, &entry)
+
+		events = append(events, LoginEvent{
+			Type: entry.Type,
+			PID:  entry.Pid,
+			User: printable(cString(entry.User[:])),
+			TTY:  printable(cString(entry.Line[:])),
+			Host: printable(cString(entry.Host[:])),
+			Time: time.Unix(int64(entry.TimeSec), int64(entry.TimeUsec)*1000),
+		})
+	}
+	return events, nil
+}
+
+// bootRecord is a system boot and how long the system ran before the next
+// boot. Up is zero for the current boot.
+type bootRecord struct {
+	Time   time.Time
+	Kernel string
+	Up     time.Duration
+}
+
+// rebootHistory returns the boots recorded in events, which are oldest first
+// as in wtmp, newest first as last(1) lists them.
+func rebootHistory(events []LoginEvent) []bootRecord {
+	var boots []bootRecord
+	for _, event := range events {
+		if event.Type == BOOT_TIME {
+			boots = append(boots, bootRecord{Time: event.Time, Kernel: event.Host})
+		}
+	}
+
+	history := make([]bootRecord, len(boots))
+	for i, boot := range boots {
+		if i+1 < len(boots) {
+			boot.Up = boots[i+1].Time.Sub(boot.Time)
+		}
+		history[len(boots)-1-i] = boot
+	}
+	return history
+}
+
+// formatElapsed formats a duration the way last(1) does: "HH:MM", with a
+// "days+" prefix for a day or more.
+func formatElapsed(d time.Duration) string {
+	minutes := int64(d / time.Minute)
+	days, hours := minutes/(24*60), minutes/60%24
+	if days > 0 {
+		return fmt.Sprintf("%d+%02d:%02d", days, hours, minutes%60)
+	}
+	return fmt.Sprintf("%02d:%02d", hours, minutes%60)
+}
+
+// displayReboots prints the reboot history in the style of "last reboot".
+func displayReboots(w io.Writer, history []bootRecord) {
+	const layout = "Mon Jan _2 15:04"
+	for i, boot := range history {
+		up := "still running"
+		if i > 0 {
+			up = fmt.Sprintf("- %s  (%s)", boot.Time.Add(boot.Up).UTC().Format("15:04"), formatElapsed(boot.Up))
+		}
+		fmt.Fprintf(w, "%-8s %-12s %-16s %s   %s\n", "reboot", "system boot", boot.Kernel, boot.Time.UTC().Format(layout), up)
+	}
+}
+
+// lastReboot prints the reboot history from wtmpPath and returns the exit
+// code.
+func lastReboot(w io.Writer) int {
+	events, err := parseWtmpFile(wtmpPath)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	displayReboots(w, rebootHistory(events))
+	return exitOK
+}
+
+// displayFailed prints failed login attempts newest first, like lastb(1).
+func displayFailed(w io.Writer, events []LoginEvent) {
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		fmt.Fprintf(w, "%-8s %-12s %-16s %s\n", event.User, event.TTY, event.Host, event.Time.UTC().Format("Mon Jan _2 15:04"))
+	}
+}
+
+// failedLogins prints the failed login attempts from btmpPath and returns the
+// exit code.
+func failedLogins(w io.Writer) int {
+	events, err := parseWtmpFile(btmpPath)
+	if errors.Is(err, fs.ErrPermission) {
+		log.Printf("Error: reading %s requires root", btmpPath)
+		return exitError
+	} else if err != nil {
+		log.Printf("Error: %v", err)
+		return exitError
+	}
+	displayFailed(w, events)
+	return exitOK
+}
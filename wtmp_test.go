@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRebootHistory tests listing boots newest first with their uptimes.
+func TestRebootHistory(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-12-amd64", 1672300800)...)
+	data = append(data, mockUtmpRecord(USER_PROCESS, "pts/0", "alice", "10.0.0.1", 1672304400)...)
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-13-amd64", 1672416000)...)
+	data = append(data, mockUtmpRecord(BOOT_TIME, "~", "reboot", "6.1.0-13-amd64", 1672502400)...)
+
+	events, err := parseWtmpFile(writeTempFile(t, "wtmp", data))
+	if err != nil {
+		t.Fatalf("parseWtmpFile failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	if events[1].User != "alice" || events[1].TTY != "pts/0" || !events[1].Time.Equal(time.Unix(1672304400, 0)) {
+		t.Errorf("Unexpected login event %+v", events[1])
+	}
+
+	history := rebootHistory(events)
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 boots, got %d", len(history))
+	}
+
+	var buf bytes.Buffer
+	displayReboots(&buf, history)
+	expected := "" +
+		"reboot   system boot  6.1.0-13-amd64   Sat Dec 31 16:00   still running\n" +
+		"reboot   system boot  6.1.0-13-amd64   Fri Dec 30 16:00   - 16:00  (1+00:00)\n" +
+		"reboot   system boot  6.1.0-12-amd64   Thu Dec 29 08:00   - 16:00  (1+08:00)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// TestFormatElapsed tests last(1)-style durations.
+func TestFormatElapsed(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{5 * time.Minute, "00:05"},
+		{2*time.Hour + 15*time.Minute, "02:15"},
+		{50 * time.Hour, "2+02:00"},
+	}
+
+	for _, test := range tests {
+		result := formatElapsed(test.duration)
+		if result != test.expected {
+			t.Errorf("formatElapsed(%v) = %q; expected %q", test.duration, result, test.expected)
+		}
+	}
+}
+
+// TestFailedLogins tests listing btmp records and reporting a missing
+// permission clearly.
+func TestFailedLogins(t *testing.T) {
+	var data []byte
+	data = append(data, mockUtmpRecord(LOGIN_PROCESS, "ssh:notty", "admin", "203.0.113.5", 1672502400)...)
+	data = append(data, mockUtmpRecord(LOGIN_PROCESS, "ssh:notty", "root", "203.0.113.9", 1672506000)...)
+
+	oldBtmpPath := btmpPath
+	btmpPath = writeTempFile(t, "btmp", data)
+	defer func() {
+		btmpPath = oldBtmpPath
+	}()
+
+	var buf bytes.Buffer
+	if code := failedLogins(&buf); code != exitOK {
+		t.Fatalf("failedLogins = %d; expected %d", code, exitOK)
+	}
+	expected := "" +
+		"root     ssh:notty    203.0.113.9      Sat Dec 31 17:00\n" +
+		"admin    ssh:notty    203.0.113.5      Sat Dec 31 16:00\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	if os.Geteuid() != 0 {
+		if err := os.Chmod(btmpPath, 0o200); err != nil {
+			t.Fatal(err)
+		}
+		if code := failedLogins(io.Discard); code != exitError {
+			t.Errorf("failedLogins on an unreadable btmp = %d; expected %d", code, exitError)
+		}
+	}
+}